@@ -0,0 +1,257 @@
+// Tool for checking balances and sending coins via a node
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+	"github.com/coocos/cryptocurrency/internal/network"
+)
+
+// Options passed as CLI flags
+type Options struct {
+	privateKey string
+	node       string
+	nodeKey    string
+	insecure   bool
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [arguments]
+
+Commands:
+  balance                                     show the balance and nonce of the wallet
+  send -to <address> -amount <n> [-fee <n>]   send coins to another address
+  cancel [-fee <n>] [-nonce <n>]              cancel a pending transaction by replacing it
+  status <id>                                 show whether a transaction has been mined
+  history [-from <n>] [-limit <n>]            list transactions sent from and to the wallet
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func parseArgs() Options {
+	options := Options{}
+	flag.StringVar(&options.privateKey, "private", "private.key", "private key path")
+	flag.StringVar(&options.node, "node", "localhost:8000", "address of the node to use")
+	flag.StringVar(&options.nodeKey, "node-key", "", "base64 encoded identity key of the node, without which the node is not authenticated")
+	flag.BoolVar(&options.insecure, "insecure", false, "talk to a node running in insecure mode over plain HTTP")
+	flag.Usage = usage
+	flag.Parse()
+	return options
+}
+
+// Wallet sends and inspects transactions for a key pair via a node
+type Wallet struct {
+	keyPair *keys.KeyPair
+	client  *network.NodeClient
+}
+
+func (w *Wallet) account() (blockchain.Account, error) {
+	account, found, err := w.client.GetAccount(w.keyPair.PublicKey)
+	if err != nil {
+		return blockchain.Account{}, err
+	}
+	// Accounts which have never received coins do not exist in the blockchain yet
+	if !found {
+		return blockchain.Account{Address: w.keyPair.PublicKey}, nil
+	}
+	return account, nil
+}
+
+func (w *Wallet) balance() error {
+	account, err := w.account()
+	if err != nil {
+		return err
+	}
+	fmt.Println("📬 Address:", w.keyPair.PublicKeyBase64)
+	fmt.Println("💰 Balance:", account.Balance)
+	fmt.Println("🔢 Nonce:", account.Nonce)
+	return nil
+}
+
+func (w *Wallet) send(args []string) error {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	to := flags.String("to", "", "base64 encoded address of the receiver")
+	amount := flags.Uint("amount", 0, "amount of coins to send")
+	fee := flags.Uint("fee", 0, "fee paid to the miner of the transaction")
+	flags.Parse(args)
+
+	receiver, err := base64.StdEncoding.DecodeString(*to)
+	if err != nil || len(receiver) != len(w.keyPair.PublicKey) {
+		return fmt.Errorf("Invalid receiver address %q", *to)
+	}
+	if *amount == 0 {
+		return fmt.Errorf("Amount needs to be greater than zero")
+	}
+	// Transactions still waiting in the pool have already used the nonces after the account nonce
+	pooled, err := w.client.GetPooledTransactions(w.keyPair.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	transaction := blockchain.NewTransaction(w.keyPair.PublicKey, receiver, *amount, *fee, pooled.NextNonce)
+	if _, err := transaction.Sign(w.keyPair.PrivateKey); err != nil {
+		return err
+	}
+	fmt.Printf("⏳ Sending %d coins with fee %d to %s...\n", *amount, *fee, *to)
+	if err := w.client.SendTransaction(*transaction); err != nil {
+		return err
+	}
+	fmt.Printf("✨ Transaction %s submitted with nonce %d\n", transaction.ID(), transaction.Nonce)
+	return nil
+}
+
+// cancel replaces a pending transaction with a transaction sending nothing to the wallet itself. The fee
+// defaults to the lowest fee the node accepts for the replacement.
+func (w *Wallet) cancel(args []string) error {
+	flags := flag.NewFlagSet("cancel", flag.ExitOnError)
+	fee := flags.Uint("fee", 0, "fee of the replacement, defaults to the lowest fee which replaces the pending transaction")
+	nonce := flags.Uint("nonce", 0, "nonce of the pending transaction, defaults to the lowest nonce in the pool")
+	flags.Parse(args)
+
+	pooled, err := w.client.GetPooledTransactions(w.keyPair.PublicKey)
+	if err != nil {
+		return err
+	}
+	if len(pooled.Transactions) == 0 {
+		return fmt.Errorf("Wallet has no transactions in the pool of the node")
+	}
+	replaced := pooled.Transactions[0]
+	if *nonce != 0 {
+		found := false
+		for _, transaction := range pooled.Transactions {
+			if transaction.Transaction.Nonce == *nonce {
+				replaced, found = transaction, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("Transaction with nonce %d is not in the pool of the node", *nonce)
+		}
+	}
+	if *fee == 0 {
+		*fee = replaced.ReplacementFee
+	}
+	if *fee < replaced.ReplacementFee {
+		return fmt.Errorf("Replacing the transaction requires a fee of at least %d", replaced.ReplacementFee)
+	}
+	*nonce = replaced.Transaction.Nonce
+
+	transaction := blockchain.NewTransaction(w.keyPair.PublicKey, w.keyPair.PublicKey, 0, *fee, *nonce)
+	if _, err := transaction.Sign(w.keyPair.PrivateKey); err != nil {
+		return err
+	}
+	fmt.Printf("⏳ Cancelling transaction with nonce %d using fee %d...\n", *nonce, *fee)
+	if err := w.client.SendTransaction(*transaction); err != nil {
+		return err
+	}
+	fmt.Printf("✨ Cancellation %s submitted with nonce %d\n", transaction.ID(), transaction.Nonce)
+	return nil
+}
+
+func (w *Wallet) status(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected a transaction ID")
+	}
+	status, err := w.client.GetTransactionStatus(args[0])
+	if err != nil {
+		return err
+	}
+	switch status.Status {
+	case blockchain.StatusIncluded:
+		fmt.Printf("✅ Included in block %d with %d confirmations\n", *status.Block, status.Confirmations)
+	case blockchain.StatusPending:
+		fmt.Println("⏳ Pending in the pool")
+	case blockchain.StatusQueued:
+		fmt.Println("⏸️  Queued in the pool until transactions with lower nonces arrive")
+	case blockchain.StatusRejected:
+		fmt.Println("❌ Rejected:", status.Reason)
+	default:
+		fmt.Println("❔ Unknown to the node")
+	}
+	return nil
+}
+
+func (w *Wallet) history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	from := flags.Int("from", 0, "number of the most recent transactions to skip")
+	limit := flags.Int("limit", 20, "number of transactions to list")
+	flags.Parse(args)
+
+	history, err := w.client.GetHistory(w.keyPair.PublicKey, *from, *limit)
+	if err != nil {
+		return err
+	}
+	for _, record := range history.Transactions {
+		transaction := record.Transaction
+		switch {
+		case bytes.Equal(transaction.Sender, w.keyPair.PublicKey):
+			fmt.Printf("Block %d: ➡️  sent %d coins with fee %d to %s (nonce %d)\n", record.Block, transaction.Amount, transaction.Fee, base64.StdEncoding.EncodeToString(transaction.Receiver), transaction.Nonce)
+		case transaction.Sender == nil:
+			fmt.Printf("Block %d: ⛏️  mined %d coins\n", record.Block, transaction.Amount)
+		default:
+			fmt.Printf("Block %d: ⬅️  received %d coins from %s\n", record.Block, transaction.Amount, base64.StdEncoding.EncodeToString(transaction.Sender))
+		}
+	}
+	fmt.Printf("Showing %d of %d transactions\n", len(history.Transactions), history.Total)
+	return nil
+}
+
+func main() {
+	options := parseArgs()
+	keyPair, err := keys.LoadKeyPair(options.privateKey)
+	if err != nil {
+		log.Fatalf("Failed to load key pair: %v\n", err)
+	}
+	var transport network.Transport = network.NewHTTPTransport()
+	if !options.insecure {
+		if transport, err = network.NewTLSTransport(nil, false); err != nil {
+			log.Fatalf("Failed to enable TLS: %v\n", err)
+		}
+	}
+	client := network.NewNodeClient(transport, options.node)
+	// The certificate of the node is self-signed, so only pinning its identity authenticates it
+	if options.nodeKey != "" {
+		if options.insecure {
+			log.Fatalln("The identity of a node running in insecure mode can not be verified")
+		}
+		nodeKey, err := base64.StdEncoding.DecodeString(options.nodeKey)
+		if err != nil || len(nodeKey) != ed25519.PublicKeySize {
+			log.Fatalf("Invalid node identity key %q\n", options.nodeKey)
+		}
+		client.PinIdentity(nodeKey)
+	}
+	wallet := Wallet{keyPair, client}
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch command := flag.Arg(0); command {
+	case "balance":
+		err = wallet.balance()
+	case "send":
+		err = wallet.send(flag.Args()[1:])
+	case "cancel":
+		err = wallet.cancel(flag.Args()[1:])
+	case "status":
+		err = wallet.status(flag.Args()[1:])
+	case "history":
+		err = wallet.history(flag.Args()[1:])
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s: %v\n", flag.Arg(0), err)
+	}
+}
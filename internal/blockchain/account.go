@@ -14,20 +14,83 @@ type Account struct {
 	Balance uint              `json:"balance"`
 }
 
-// Accounts represents all the accounts within the blockchain
+// Accounts represents all the accounts within the blockchain. Accounts taken as a snapshot of other
+// accounts only hold the accounts they have changed and read the rest from the accounts beneath them.
 type Accounts struct {
 	accounts map[string]*Account
+	base     *Accounts
 }
 
 // NewAccounts returns an empty Accounts struct
 func NewAccounts() *Accounts {
-	return &Accounts{make(map[string]*Account)}
+	return &Accounts{make(map[string]*Account), nil}
+}
+
+// Snapshot returns a copy-on-write view of the accounts. Changes to the snapshot do not affect the
+// original accounts, but the original accounts must not change while the snapshot is in use.
+func (a *Accounts) Snapshot() *Accounts {
+	return &Accounts{make(map[string]*Account), a}
+}
+
+// lookup finds an account from the accounts or from the accounts beneath them. An account which
+// exists in a base but has been removed in a snapshot is stored as nil in the snapshot.
+func (a *Accounts) lookup(accountId string) (*Account, bool) {
+	for layer := a; layer != nil; layer = layer.base {
+		if account, exists := layer.accounts[accountId]; exists {
+			return account, account != nil
+		}
+	}
+	return nil, false
+}
+
+// writable returns an account which can be modified without affecting the accounts beneath
+func (a *Accounts) writable(accountId string) (*Account, bool) {
+	if account, exists := a.accounts[accountId]; exists {
+		return account, account != nil
+	}
+	account, exists := a.lookup(accountId)
+	if !exists {
+		return nil, false
+	}
+	copy := *account
+	a.accounts[accountId] = &copy
+	return &copy, true
+}
+
+func (a *Accounts) set(accountId string, account *Account) {
+	if account == nil && a.base == nil {
+		delete(a.accounts, accountId)
+		return
+	}
+	a.accounts[accountId] = account
+}
+
+// merge writes the changes made to a snapshot of the accounts into the accounts and returns the
+// previous state of every changed account, so that the changes can be reverted later
+func (a *Accounts) merge(snapshot *Accounts) map[string]*Account {
+	previous := make(map[string]*Account, len(snapshot.accounts))
+	for accountId, account := range snapshot.accounts {
+		previous[accountId], _ = a.lookup(accountId)
+		a.set(accountId, account)
+	}
+	return previous
+}
+
+// revert restores accounts to the previous states returned by merge
+func (a *Accounts) revert(previous map[string]*Account) {
+	for accountId, account := range previous {
+		if account != nil {
+			copy := *account
+			account = &copy
+		}
+		a.set(accountId, account)
+	}
 }
 
 // Read returns the account matching the address or an error if the account is unknown
 func (a *Accounts) Read(address ed25519.PublicKey) (*Account, error) {
 	accountId := base64.StdEncoding.EncodeToString(address)
-	account, exists := a.accounts[accountId]
+	account, exists := a.lookup(accountId)
 	if !exists {
 		return nil, fmt.Errorf("Account %s does not exist", accountId)
 	}
@@ -36,9 +99,18 @@ func (a *Accounts) Read(address ed25519.PublicKey) (*Account, error) {
 
 // ListAccounts returns all known accounts
 func (a *Accounts) ListAccounts() []Account {
-	accounts := make([]Account, len(a.accounts))
-	for _, account := range a.accounts {
-		accounts = append(accounts, *account)
+	seen := make(map[string]bool)
+	accounts := make([]Account, 0, len(a.accounts))
+	for layer := a; layer != nil; layer = layer.base {
+		for accountId, account := range layer.accounts {
+			if seen[accountId] {
+				continue
+			}
+			seen[accountId] = true
+			if account != nil {
+				accounts = append(accounts, *account)
+			}
+		}
 	}
 	return accounts
 }
@@ -49,7 +121,11 @@ func (a *Accounts) ApplyTransaction(transaction Transaction) error {
 		return errors.New("Invalid transaction signature")
 	}
 	if !transaction.IsCoinbase() {
-		if err := a.subtract(transaction.Sender, transaction.Amount, transaction.Nonce); err != nil {
+		cost, err := transaction.Cost()
+		if err != nil {
+			return err
+		}
+		if err := a.subtract(transaction.Sender, cost, transaction.Nonce); err != nil {
 			return err
 		}
 	}
@@ -57,20 +133,38 @@ func (a *Accounts) ApplyTransaction(transaction Transaction) error {
 	return nil
 }
 
+// ApplyBlock applies all the transactions in the block or returns an error if any of them is invalid.
+// The accounts are left partially updated on error, so the block should be applied to a copy if the
+// accounts need to remain usable afterwards.
+func (a *Accounts) ApplyBlock(block *Block) error {
+	seen := make(map[string]bool)
+	for i, transaction := range block.Transactions {
+		signature := base64.StdEncoding.EncodeToString(transaction.Signature)
+		if !transaction.IsCoinbase() && seen[signature] {
+			return fmt.Errorf("Transaction %d in block %d is a duplicate", i, block.Number)
+		}
+		seen[signature] = true
+		if err := a.ApplyTransaction(transaction); err != nil {
+			return fmt.Errorf("Transaction %d in block %d is invalid: %v", i, block.Number, err)
+		}
+	}
+	return nil
+}
+
 // AccountsFromBlockchain generates the current account states from the blockchain
-func AccountsFromBlockchain(blocks []*Block) *Accounts {
+func AccountsFromBlockchain(blocks []*Block) (*Accounts, error) {
 	accounts := NewAccounts()
 	for _, block := range blocks {
-		for _, transaction := range block.Transactions {
-			accounts.ApplyTransaction(transaction)
+		if err := accounts.ApplyBlock(block); err != nil {
+			return nil, err
 		}
 	}
-	return accounts
+	return accounts, nil
 }
 
 func (a *Accounts) add(address ed25519.PublicKey, amount uint) {
 	accountId := base64.StdEncoding.EncodeToString(address)
-	account, exists := a.accounts[accountId]
+	account, exists := a.writable(accountId)
 	if !exists {
 		a.accounts[accountId] = &Account{
 			Address: address,
@@ -84,7 +178,7 @@ func (a *Accounts) add(address ed25519.PublicKey, amount uint) {
 
 func (a *Accounts) subtract(address ed25519.PublicKey, amount uint, nonce uint) error {
 	accountId := base64.StdEncoding.EncodeToString(address)
-	account, exists := a.accounts[accountId]
+	account, exists := a.lookup(accountId)
 	if !exists {
 		return fmt.Errorf("Account %v not found", accountId)
 	}
@@ -94,6 +188,7 @@ func (a *Accounts) subtract(address ed25519.PublicKey, amount uint, nonce uint)
 	if amount > account.Balance {
 		return fmt.Errorf("Account %v has insufficient balance", accountId)
 	}
+	account, _ = a.writable(accountId)
 	account.Balance -= amount
 	account.Nonce += 1
 	return nil
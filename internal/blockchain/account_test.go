@@ -12,7 +12,7 @@ func TestAccount(t *testing.T) {
 		keys := keys.NewKeyPair()
 		accounts := NewAccounts()
 
-		if err := accounts.ApplyTransaction(CoinbaseTransactionTo(keys.PublicKey)); err != nil {
+		if err := accounts.ApplyTransaction(CoinbaseTransactionTo(keys.PublicKey, 0)); err != nil {
 			t.Error("Failed to apply coinbase transaction:", err)
 		}
 
@@ -29,8 +29,8 @@ func TestAccount(t *testing.T) {
 		receiver := keys.NewKeyPair()
 		accounts := NewAccounts()
 
-		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey))
-		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 5, 1)
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 5, 0, 1)
 		transaction.Sign(sender.PrivateKey)
 		if err := accounts.ApplyTransaction(*transaction); err != nil {
 			t.Error("Failed to apply transaction", err)
@@ -53,8 +53,8 @@ func TestAccount(t *testing.T) {
 		receiver := keys.NewKeyPair()
 		accounts := NewAccounts()
 
-		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey))
-		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 5, 1)
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 5, 0, 1)
 		transaction.Sign(sender.PrivateKey)
 		accounts.ApplyTransaction(*transaction)
 
@@ -67,7 +67,7 @@ func TestAccount(t *testing.T) {
 		receiver := keys.NewKeyPair()
 		accounts := NewAccounts()
 
-		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 10, 1)
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 10, 0, 1)
 		transaction.Sign(sender.PrivateKey)
 		if err := accounts.ApplyTransaction(*transaction); err == nil {
 			t.Error("Applied invalid transaction")
@@ -78,7 +78,10 @@ func TestAccount(t *testing.T) {
 		chain := NewBlockchain(miner)
 
 		chain.MineBlock()
-		accounts := AccountsFromBlockchain(chain.blocks)
+		accounts, err := AccountsFromBlockchain(chain.blocks)
+		if err != nil {
+			t.Fatal("Failed to apply blockchain to accounts:", err)
+		}
 		account, err := accounts.Read(miner.PublicKey)
 		if err != nil {
 			t.Error("Failed to read accounts from blockchain:", err)
@@ -87,4 +90,88 @@ func TestAccount(t *testing.T) {
 			t.Errorf("Account balance based on blockchain should be %v but is %v\n", 10, account.Balance)
 		}
 	})
+	t.Run("Test that sender pays transaction fee", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+		accounts := NewAccounts()
+
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 5, 2, 1)
+		transaction.Sign(sender.PrivateKey)
+		if err := accounts.ApplyTransaction(*transaction); err != nil {
+			t.Error("Failed to apply transaction", err)
+		}
+
+		senderAccount, _ := accounts.Read(sender.PublicKey)
+		if senderAccount.Balance != 3 {
+			t.Errorf("Expected account balance %v, real account balance %v\n", 3, senderAccount.Balance)
+		}
+		receiverAccount, _ := accounts.Read(receiver.PublicKey)
+		if receiverAccount.Balance != 5 {
+			t.Errorf("Expected account balance %v, real account balance %v\n", 5, receiverAccount.Balance)
+		}
+	})
+	t.Run("Test rejecting block with duplicate transactions", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+		accounts := NewAccounts()
+
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 1, 0, 1)
+		transaction.Sign(sender.PrivateKey)
+		block := &Block{Transactions: []Transaction{CoinbaseTransactionTo(receiver.PublicKey, 0), *transaction, *transaction}}
+
+		if err := accounts.ApplyBlock(block); err == nil {
+			t.Error("Applied block with duplicate transactions")
+		}
+	})
+	t.Run("Test that snapshot does not change original accounts", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+		accounts := NewAccounts()
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+
+		snapshot := accounts.Snapshot()
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 4, 0, 1)
+		transaction.Sign(sender.PrivateKey)
+		if err := snapshot.ApplyTransaction(*transaction); err != nil {
+			t.Fatal("Failed to apply transaction to snapshot:", err)
+		}
+
+		if account, _ := snapshot.Read(sender.PublicKey); account.Balance != 6 {
+			t.Errorf("Expected snapshot balance %v, real balance %v\n", 6, account.Balance)
+		}
+		if account, _ := accounts.Read(sender.PublicKey); account.Balance != 10 || account.Nonce != 0 {
+			t.Error("Applying transaction to snapshot changed original account")
+		}
+		if _, err := accounts.Read(receiver.PublicKey); err == nil {
+			t.Error("Account created in snapshot exists in original accounts")
+		}
+	})
+	t.Run("Test reverting merged snapshot", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+		accounts := NewAccounts()
+		accounts.ApplyTransaction(CoinbaseTransactionTo(sender.PublicKey, 0))
+
+		snapshot := accounts.Snapshot()
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 4, 0, 1)
+		transaction.Sign(sender.PrivateKey)
+		snapshot.ApplyTransaction(*transaction)
+		previous := accounts.merge(snapshot)
+
+		if account, _ := accounts.Read(receiver.PublicKey); account == nil || account.Balance != 4 {
+			t.Fatal("Merged snapshot is not reflected in accounts")
+		}
+		accounts.revert(previous)
+		if _, err := accounts.Read(receiver.PublicKey); err == nil {
+			t.Error("Reverted account still exists")
+		}
+		if account, _ := accounts.Read(sender.PublicKey); account.Balance != 10 || account.Nonce != 0 {
+			t.Error("Reverted account does not match its previous state")
+		}
+		if len(accounts.ListAccounts()) != 1 {
+			t.Errorf("Expected %d account after revert but found %d\n", 1, len(accounts.ListAccounts()))
+		}
+	})
 }
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// DefaultCheckpointInterval is the default number of blocks between account state checkpoints in
+// archival mode
+const DefaultCheckpointInterval = 1000
+
+// ErrNotArchival is returned for queries of past account states when the blockchain is not archival
+var ErrNotArchival = errors.New("Past account states are only kept in archival mode")
+
+// archive keeps the account states of past blocks on the main chain. A checkpoint of all accounts is kept
+// every interval blocks, and every block keeps the new states of the accounts it changed. The state of
+// an account at any height is then found by looking through at most interval blocks.
+type archive struct {
+	interval    int
+	checkpoints map[int]map[string]Account
+	// deltas holds the accounts changed by each block on the main chain by block number
+	deltas []map[string]Account
+}
+
+func newArchive(interval int) *archive {
+	if interval < 1 {
+		interval = DefaultCheckpointInterval
+	}
+	return &archive{
+		interval:    interval,
+		checkpoints: make(map[int]map[string]Account),
+	}
+}
+
+// connect records the changes a block appended to the main chain made to the accounts. The accounts
+// need to have the block applied already.
+func (a *archive) connect(block *Block, accounts *Accounts, changed map[string]*Account) {
+	delta := make(map[string]Account, len(changed))
+	for accountId := range changed {
+		if account, exists := accounts.lookup(accountId); exists {
+			delta[accountId] = *account
+		}
+	}
+	a.deltas = append(a.deltas[:block.Number], delta)
+	if block.Number%a.interval == 0 {
+		checkpoint := make(map[string]Account)
+		for _, account := range accounts.ListAccounts() {
+			checkpoint[base64.StdEncoding.EncodeToString(account.Address)] = account
+		}
+		a.checkpoints[block.Number] = checkpoint
+	}
+}
+
+// disconnect forgets the changes of a block removed from the main chain
+func (a *archive) disconnect(block *Block) {
+	a.deltas = a.deltas[:block.Number]
+	delete(a.checkpoints, block.Number)
+}
+
+// account returns the state of the account after the block at the given height
+func (a *archive) account(address ed25519.PublicKey, height int) (Account, error) {
+	if height < 0 || height >= len(a.deltas) {
+		return Account{}, fmt.Errorf("Block %d is not on the main chain", height)
+	}
+	accountId := base64.StdEncoding.EncodeToString(address)
+	checkpoint := height - height%a.interval
+	for number := height; number > checkpoint; number-- {
+		if account, exists := a.deltas[number][accountId]; exists {
+			return account, nil
+		}
+	}
+	if account, exists := a.checkpoints[checkpoint][accountId]; exists {
+		return account, nil
+	}
+	return Account{}, fmt.Errorf("Account %s did not exist at block %d", accountId, height)
+}
+
+// accounts returns the states of all accounts after the block at the given height
+func (a *archive) accounts(height int) ([]Account, error) {
+	if height < 0 || height >= len(a.deltas) {
+		return nil, fmt.Errorf("Block %d is not on the main chain", height)
+	}
+	checkpoint := height - height%a.interval
+	states := make(map[string]Account, len(a.checkpoints[checkpoint]))
+	for accountId, account := range a.checkpoints[checkpoint] {
+		states[accountId] = account
+	}
+	for number := checkpoint + 1; number <= height; number++ {
+		for accountId, account := range a.deltas[number] {
+			states[accountId] = account
+		}
+	}
+	accounts := make([]Account, 0, len(states))
+	for _, account := range states {
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// AccountAt returns the state of the account matching the address after the block at the given height on
+// the main chain. ErrNotArchival is returned unless the blockchain is archival.
+func (b *Blockchain) AccountAt(address ed25519.PublicKey, height int) (Account, error) {
+	b.RLock()
+	defer b.RUnlock()
+	if b.archive == nil {
+		return Account{}, ErrNotArchival
+	}
+	return b.archive.account(address, height)
+}
+
+// ListAccountsAt returns the states of all accounts after the block at the given height on the main
+// chain. ErrNotArchival is returned unless the blockchain is archival.
+func (b *Blockchain) ListAccountsAt(height int) ([]Account, error) {
+	b.RLock()
+	defer b.RUnlock()
+	if b.archive == nil {
+		return nil, ErrNotArchival
+	}
+	return b.archive.accounts(height)
+}
@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestArchive(t *testing.T) {
+	miner := keys.NewKeyPair()
+	receiver := keys.NewKeyPair()
+
+	t.Run("Test querying account states at past heights", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis(), WithArchive(3))
+		states := []Account{{}}
+		// The miner spends coins from the previous blocks in every block after the first one
+		for nonce := uint(0); nonce < 7; nonce++ {
+			if nonce > 0 {
+				transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 1, 1, nonce)
+				transaction.Sign(miner.PrivateKey)
+				chain.AddTransaction(*transaction)
+			}
+			chain.MineBlock()
+			account, _ := chain.Account(miner.PublicKey)
+			states = append(states, account)
+		}
+
+		for height := 1; height < len(states); height++ {
+			account, err := chain.AccountAt(miner.PublicKey, height)
+			if err != nil || account.Balance != states[height].Balance || account.Nonce != states[height].Nonce {
+				t.Errorf("Expected %+v at height %d but got %+v: %v", states[height], height, account, err)
+			}
+			accounts, err := chain.ListAccountsAt(height)
+			if err != nil || (height == 1 && len(accounts) != 1) || (height > 2 && len(accounts) != 2) {
+				t.Errorf("Unexpected accounts at height %d: %+v %v", height, accounts, err)
+			}
+		}
+		if _, err := chain.AccountAt(receiver.PublicKey, 1); err == nil {
+			t.Error("Account was found before it existed")
+		}
+		if _, err := chain.AccountAt(miner.PublicKey, len(states)); err == nil {
+			t.Error("Account was found above the tip")
+		}
+	})
+	t.Run("Test forgetting states of orphaned blocks", func(t *testing.T) {
+		firstChain := NewBlockchain(miner, withTestGenesis(), WithArchive(2))
+		secondChain := NewBlockchain(receiver, withTestGenesis())
+		firstChain.MineBlock()
+		for i := 0; i < 3; i++ {
+			secondChain.MineBlock()
+		}
+		for _, block := range secondChain.blocks[1:] {
+			if err := firstChain.addBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := firstChain.AccountAt(miner.PublicKey, 1); err == nil {
+			t.Error("State of orphaned block was kept")
+		}
+		if account, err := firstChain.AccountAt(receiver.PublicKey, 3); err != nil || account.Balance != 3*CoinbaseTransactionAmount {
+			t.Errorf("Unexpected state after reorganization: %+v %v", account, err)
+		}
+	})
+	t.Run("Test refusing past queries without archival mode", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		if _, err := chain.AccountAt(miner.PublicKey, 0); err != ErrNotArchival {
+			t.Errorf("Expected archival mode error but got %v", err)
+		}
+	})
+}
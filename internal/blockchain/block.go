@@ -5,10 +5,9 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/bits"
+	"math"
 	"time"
 )
 
@@ -16,15 +15,29 @@ import (
 type Block struct {
 	Number       int           `json:"number"`
 	Time         time.Time     `json:"time"`
+	Difficulty   uint64        `json:"difficulty"`
+	MerkleRoot   []byte        `json:"merkleRoot"`
 	Transactions []Transaction `json:"transactions"`
 	Nonce        int           `json:"nonce"`
 	PreviousHash []byte        `json:"previousHash"`
 	Hash         []byte        `json:"hash"`
 }
 
+// BlockHeader holds the fields of a block which are covered by the block hash. The transactions are
+// covered via the Merkle root, so the hash does not depend on the size of the block.
+type BlockHeader struct {
+	Number       int       `json:"number"`
+	Time         time.Time `json:"time"`
+	Difficulty   uint64    `json:"difficulty"`
+	PreviousHash []byte    `json:"previousHash"`
+	MerkleRoot   []byte    `json:"merkleRoot"`
+	Nonce        int       `json:"nonce"`
+}
+
 const (
 	maxTransactionsPerBlock = 64
-	baseDifficulty          = 20
+	genesisDifficulty       = 1 << 21
+	maxFutureBlockTime      = 2 * time.Hour
 )
 
 // String returns the string representation of a block
@@ -33,10 +46,12 @@ func (b Block) String() string {
 }
 
 // NewBlock creates a new block
-func NewBlock(number int, previousHash []byte, transactions []Transaction, nonce int) *Block {
+func NewBlock(number int, previousHash []byte, difficulty uint64, transactions []Transaction, nonce int) *Block {
 	block := Block{
 		Number:       number,
 		Time:         time.Now().UTC(),
+		Difficulty:   difficulty,
+		MerkleRoot:   ComputeMerkleRoot(transactions),
 		Transactions: transactions,
 		PreviousHash: previousHash,
 		Nonce:        nonce,
@@ -47,39 +62,143 @@ func NewBlock(number int, previousHash []byte, transactions []Transaction, nonce
 
 // GenesisBlock returns the fixed first block in the blockchain
 func GenesisBlock() *Block {
-	genesisHash, _ := hex.DecodeString("000002be9afbfdaa977028a51d10bd590f9b56b03c3f570b8723e3809dc439ba")
+	genesisHash, _ := hex.DecodeString("00000500b89978b6b6d7f91026fb63c901458f1942c54529662556781208bcb1")
 	return &Block{
 		Number:       0,
 		Time:         time.Date(2021, time.May, 1, 6, 0, 0, 0, time.UTC),
+		Difficulty:   genesisDifficulty,
+		MerkleRoot:   ComputeMerkleRoot(nil),
 		PreviousHash: nil,
-		Nonce:        3999606801082803789,
+		Nonce:        3999606801083946915,
 		Hash:         genesisHash,
 	}
 }
 
-// ComputeHash computes the hash for the block
-func (b *Block) ComputeHash() []byte {
-	// Exclude the hash field itself when hashing the block
-	copy := Block{
+func transactionHashes(transactions []Transaction) [][]byte {
+	hashes := make([][]byte, len(transactions))
+	for i, transaction := range transactions {
+		hashes[i] = transaction.Hash()
+	}
+	return hashes
+}
+
+// ComputeMerkleRoot computes the Merkle root over the hashes of the transactions
+func ComputeMerkleRoot(transactions []Transaction) []byte {
+	return MerkleRoot(transactionHashes(transactions))
+}
+
+// BlockFromHeader returns a block without transactions matching the header
+func BlockFromHeader(header BlockHeader) *Block {
+	return &Block{
+		Number:       header.Number,
+		Time:         header.Time,
+		Difficulty:   header.Difficulty,
+		MerkleRoot:   header.MerkleRoot,
+		Nonce:        header.Nonce,
+		PreviousHash: header.PreviousHash,
+		Hash:         header.Hash(),
+	}
+}
+
+// Header returns the header of the block
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
 		Number:       b.Number,
 		Time:         b.Time,
-		Transactions: b.Transactions,
-		Nonce:        b.Nonce,
+		Difficulty:   b.Difficulty,
 		PreviousHash: b.PreviousHash,
+		MerkleRoot:   b.MerkleRoot,
+		Nonce:        b.Nonce,
 	}
-	bytes, err := json.Marshal(copy)
-	if err != nil {
-		log.Fatalf("Failed to hash block: %v\n", err)
+}
+
+// Hash computes the hash of the binary encoding of the block header
+func (h *BlockHeader) Hash() []byte {
+	bytes, _ := h.MarshalBinary()
+	hash := sha256.Sum256(bytes)
+	return hash[:]
+}
+
+// ComputeHash computes the hash for the block, which is the hash of its header
+func (b *Block) ComputeHash() []byte {
+	header := b.Header()
+	return header.Hash()
+}
+
+// TransactionProof returns a Merkle proof that the transaction at the given index is included in the block
+func (b *Block) TransactionProof(index int) []MerkleStep {
+	return MerkleProof(transactionHashes(b.Transactions), index)
+}
+
+// Work returns the expected number of hashes needed to mine the block
+func (b *Block) Work() uint64 {
+	return b.Difficulty
+}
+
+// MeetsDifficulty indicates whether the block hash satisfies the proof-of-work difficulty of the block
+func (b *Block) MeetsDifficulty() bool {
+	if b.Difficulty == 0 || len(b.Hash) < 8 {
+		return false
+	}
+	return binary.BigEndian.Uint64(b.Hash) <= math.MaxUint64/b.Difficulty
+}
+
+// Fees returns the sum of the fees of the transactions in the block
+func (b *Block) Fees() (uint, error) {
+	var fees uint
+	for _, transaction := range b.Transactions {
+		if transaction.Sender == nil {
+			continue
+		}
+		if fees+transaction.Fee < fees {
+			return 0, errors.New("Block fees overflow")
+		}
+		fees += transaction.Fee
+	}
+	return fees, nil
+}
+
+// hasValidHeader indicates if the header of the block links to the previous block and meets its difficulty
+func (b *Block) hasValidHeader(previous *Block) bool {
+	if b.Number != previous.Number+1 || !bytes.Equal(b.PreviousHash, previous.Hash) {
+		return false
+	}
+	if b.Time.Before(previous.Time) || b.Time.After(time.Now().Add(maxFutureBlockTime)) {
+		return false
+	}
+	if !bytes.Equal(b.Hash, b.ComputeHash()) {
+		return false
 	}
+	return b.MeetsDifficulty()
+}
 
-	hash := sha256.New()
-	hash.Write(bytes)
-	return hash.Sum(nil)
+// ErrInvalidProofOfWork is returned for blocks whose hash does not match their header or meet their difficulty
+var ErrInvalidProofOfWork = errors.New("Block has invalid proof-of-work")
+
+// CheckIntegrity checks the parts of the block which can be validated without the rest of the blockchain:
+// the proof-of-work, the transaction signatures and the Merkle root. It is cheap compared to adding the
+// block to the blockchain, so blocks received from peers are checked with it before they are queued.
+func (b *Block) CheckIntegrity() error {
+	if !bytes.Equal(b.Hash, b.ComputeHash()) || !b.MeetsDifficulty() {
+		return ErrInvalidProofOfWork
+	}
+	if len(b.Transactions) < 1 || len(b.Transactions) > maxTransactionsPerBlock {
+		return fmt.Errorf("Block has %d transactions", len(b.Transactions))
+	}
+	for i := range b.Transactions {
+		if !b.Transactions[i].ValidSignature() {
+			return ErrInvalidSignature
+		}
+	}
+	if !bytes.Equal(b.MerkleRoot, ComputeMerkleRoot(b.Transactions)) {
+		return errors.New("Block Merkle root does not match its transactions")
+	}
+	return nil
 }
 
 // IsValid indicates if the block is valid
 func (b *Block) IsValid(previous *Block) bool {
-	if b.Number != previous.Number+1 || !bytes.Equal(b.PreviousHash, previous.Hash) {
+	if !b.hasValidHeader(previous) {
 		return false
 	}
 	if len(b.Transactions) < 1 || len(b.Transactions) > maxTransactionsPerBlock {
@@ -88,8 +207,14 @@ func (b *Block) IsValid(previous *Block) bool {
 	if !b.Transactions[0].IsCoinbase() {
 		return false
 	}
-	if !bytes.Equal(b.Hash, b.ComputeHash()) {
+	for _, transaction := range b.Transactions[1:] {
+		if transaction.Sender == nil {
+			return false
+		}
+	}
+	fees, err := b.Fees()
+	if err != nil || b.Transactions[0].Amount != CoinbaseTransactionAmount+fees {
 		return false
 	}
-	return bits.LeadingZeros64(binary.BigEndian.Uint64(b.Hash)) > baseDifficulty
+	return bytes.Equal(b.MerkleRoot, ComputeMerkleRoot(b.Transactions))
 }
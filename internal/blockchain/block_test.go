@@ -5,19 +5,92 @@ import (
 	"encoding/hex"
 	"testing"
 	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
 )
 
 func TestBlock(t *testing.T) {
 	t.Run("Test hashing a block", func(t *testing.T) {
 		genesisBlock := GenesisBlock()
-		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, nil, 1)
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, genesisBlock.Difficulty, nil, 1)
 		block.Time = time.Date(2021, time.January, 1, 6, 0, 0, 0, time.UTC)
 
 		hash := block.ComputeHash()
-		expectedHash, _ := hex.DecodeString("0323204fb3d384837511fef3da59fc5324655e0376a2d3e624c11d418b873a45")
+		expectedHash, _ := hex.DecodeString("1257f59bb81184d26debda65eb1866cc73476ca27f560c27ccf5987e45a1e941")
 
 		if !bytes.Equal(hash, expectedHash) {
 			t.Errorf("Block hash %x differs from expected %x\n", hash, expectedHash)
 		}
 	})
+	t.Run("Test that coinbase must equal subsidy plus fees", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		miner := keys.NewKeyPair()
+		transaction := NewTransaction(sender.PublicKey, miner.PublicKey, 1, 3, 1)
+		transaction.Sign(sender.PrivateKey)
+
+		genesisBlock := GenesisBlock()
+		for _, coinbase := range []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0), CoinbaseTransactionTo(miner.PublicKey, 4)} {
+			block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{coinbase, *transaction}, 0)
+			if block.IsValid(genesisBlock) {
+				t.Errorf("Block with coinbase amount %d was considered valid\n", coinbase.Amount)
+			}
+		}
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 3), *transaction}, 0)
+		if !block.IsValid(genesisBlock) {
+			t.Error("Block with coinbase collecting fees was not considered valid")
+		}
+	})
+	t.Run("Test that changing a transaction invalidates the block", func(t *testing.T) {
+		miner := keys.NewKeyPair()
+		genesisBlock := GenesisBlock()
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0)}, 0)
+		if !block.IsValid(genesisBlock) {
+			t.Fatal("Block should be valid before changing its transactions")
+		}
+
+		block.Transactions[0].Receiver = keys.NewKeyPair().PublicKey
+		if block.IsValid(genesisBlock) {
+			t.Error("Block with changed transaction was considered valid")
+		}
+	})
+	t.Run("Test proving transaction inclusion with block header", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		miner := keys.NewKeyPair()
+		transaction := NewTransaction(sender.PublicKey, miner.PublicKey, 1, 0, 1)
+		transaction.Sign(sender.PrivateKey)
+
+		genesisBlock := GenesisBlock()
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0), *transaction}, 0)
+		header := block.Header()
+		if !VerifyMerkleProof(transaction.Hash(), block.TransactionProof(1), header.MerkleRoot) {
+			t.Error("Transaction proof is not valid")
+		}
+		if !bytes.Equal(header.Hash(), block.Hash) {
+			t.Error("Header hash does not match block hash")
+		}
+	})
+	t.Run("Test checking block integrity without blockchain", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		miner := keys.NewKeyPair()
+		transaction := NewTransaction(sender.PublicKey, miner.PublicKey, 1, 0, 1)
+		transaction.Sign(sender.PrivateKey)
+
+		genesisBlock := GenesisBlock()
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0), *transaction}, 0)
+		if err := block.CheckIntegrity(); err != nil {
+			t.Fatalf("Valid block failed integrity check: %v", err)
+		}
+
+		forged := *block
+		forged.Transactions = []Transaction{forged.Transactions[0], *transaction}
+		forged.Transactions[1].Amount = 2
+		if err := forged.CheckIntegrity(); err != ErrInvalidSignature {
+			t.Errorf("Block with forged transaction failed with %v", err)
+		}
+		forged = *block
+		forged.Nonce++
+		if err := forged.CheckIntegrity(); err != ErrInvalidProofOfWork {
+			t.Errorf("Block with changed nonce failed with %v", err)
+		}
+	})
 }
@@ -1,78 +1,535 @@
 package blockchain
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
-	"math"
-	"runtime"
+	"sync"
+	"time"
 
 	"github.com/coocos/cryptocurrency/internal/keys"
 )
 
 // Blockchain represents a full blockchain
 type Blockchain struct {
-	blocks         []*Block
-	pool           map[string]Transaction
-	keyPair        *keys.KeyPair
-	externalBlocks chan Block
+	sync.RWMutex
+	blocks              []*Block
+	index               map[string]*chainLink
+	accounts            *Accounts
+	pool                *mempool
+	keyPair             *keys.KeyPair
+	targetBlockInterval time.Duration
+	store               BlockStore
+	miner               *Miner
+	genesis             *Block
+	archive             *archive
+	// included locates the transactions on the main chain by transaction ID
+	included map[string]transactionLocation
+	// The miner is signalled via these when transactions arrive or blocks are added outside of it
+	poolUpdates             chan struct{}
+	tipUpdates              chan struct{}
+	templateRefreshInterval time.Duration
+}
+
+// transactionLocation locates a transaction on the main chain by block number and index within the block
+type transactionLocation struct {
+	block int
+	index int
+}
+
+// Option configures a blockchain
+type Option func(*Blockchain)
+
+// WithTargetBlockInterval sets the average time between blocks which the mining difficulty is adjusted
+// towards. All nodes in the network need to use the same interval or they will reject each other's blocks.
+func WithTargetBlockInterval(interval time.Duration) Option {
+	return func(b *Blockchain) {
+		b.targetBlockInterval = interval
+	}
+}
+
+// WithGenesis starts the blockchain from the given genesis block instead of the fixed one, e.g. to
+// run a separate test network
+func WithGenesis(genesis *Block) Option {
+	return func(b *Blockchain) {
+		b.genesis = genesis
+	}
+}
+
+// WithTemplateRefreshInterval sets how often the block being mined is rebuilt even if the pool has not
+// changed, which also moves the timestamp of the block forward
+func WithTemplateRefreshInterval(interval time.Duration) Option {
+	return func(b *Blockchain) {
+		b.templateRefreshInterval = interval
+	}
+}
+
+// WithMempoolLimits sets how many transactions the pool holds at most and how long transactions are kept
+// in the pool before they are dropped if they do not make it into a block
+func WithMempoolLimits(size int, maxAge time.Duration) Option {
+	return func(b *Blockchain) {
+		b.pool.maxSize = size
+		b.pool.maxAge = maxAge
+	}
+}
+
+// WithReplacementFeeBump sets the percentage by which a transaction needs to raise the fee of a pooled
+// transaction with the same sender and nonce to replace it
+func WithReplacementFeeBump(percent uint) Option {
+	return func(b *Blockchain) {
+		b.pool.feeBump = percent
+	}
+}
+
+// WithArchive keeps the past account states of the main chain, so that the states at any height can be
+// queried. A checkpoint of all accounts is kept every interval blocks along with the changes of every
+// block, which trades memory for faster queries as the interval shrinks.
+func WithArchive(interval int) Option {
+	return func(b *Blockchain) {
+		b.archive = newArchive(interval)
+	}
+}
+
+// chainLink links a known block to its parent and tracks the cumulative work of its branch. Blocks on
+// the main chain also keep the previous states of the accounts they changed, so they can be rolled back.
+type chainLink struct {
+	block  *Block
+	parent *chainLink
+	work   uint64
+	undo   map[string]*Account
+}
+
+// WithStore persists accepted blocks to the given store. Blocks already in the store are loaded and
+// validated again when the blockchain is created.
+func WithStore(store BlockStore) Option {
+	return func(b *Blockchain) {
+		b.store = store
+	}
 }
 
 // NewBlockchain returns a new blockchain with a genesis block
-func NewBlockchain(keyPair *keys.KeyPair) *Blockchain {
+func NewBlockchain(keyPair *keys.KeyPair, options ...Option) *Blockchain {
 	if keyPair == nil {
 		log.Println("No key pair given - generating a new one")
 		keyPair = keys.NewKeyPair()
 	}
 	blockchain := Blockchain{
-		keyPair:        keyPair,
-		pool:           make(map[string]Transaction),
-		externalBlocks: make(chan Block, 128),
+		keyPair:                 keyPair,
+		index:                   make(map[string]*chainLink),
+		accounts:                NewAccounts(),
+		included:                make(map[string]transactionLocation),
+		pool:                    newMempool(DefaultMempoolSize, DefaultMempoolMaxAge),
+		targetBlockInterval:     DefaultTargetBlockInterval,
+		miner:                   NewMiner(),
+		poolUpdates:             make(chan struct{}, 1),
+		tipUpdates:              make(chan struct{}, 1),
+		templateRefreshInterval: DefaultTemplateRefreshInterval,
+	}
+	for _, option := range options {
+		option(&blockchain)
+	}
+	if blockchain.genesis == nil {
+		blockchain.genesis = GenesisBlock()
+	}
+	blockchain.addBlock(blockchain.genesis)
+	if blockchain.store != nil {
+		blockchain.loadStore()
 	}
-	blockchain.addBlock(GenesisBlock())
 	return &blockchain
 }
 
 // LastBlock returns the last block in the blockchain
 func (b *Blockchain) LastBlock() *Block {
+	b.RLock()
+	defer b.RUnlock()
+	return b.lastBlock()
+}
+
+func (b *Blockchain) lastBlock() *Block {
 	if len(b.blocks) > 0 {
 		return b.blocks[len(b.blocks)-1]
 	}
 	return nil
 }
 
-// SubmitExternalBlock sends an externally received block to the blockchain
-func (b *Blockchain) SubmitExternalBlock(block *Block) {
-	b.externalBlocks <- *block
+// Genesis returns the first block of the blockchain
+func (b *Blockchain) Genesis() *Block {
+	b.RLock()
+	defer b.RUnlock()
+	return b.blocks[0]
+}
+
+// CheckDifficulty returns ErrWrongDifficulty if the parent of the block is known and the block does not
+// have the difficulty expected after it. The proof-of-work of a block only proves work if the difficulty
+// is checked, so this is cheap enough to do before accepting blocks from other nodes.
+func (b *Blockchain) CheckDifficulty(block *Block) error {
+	b.RLock()
+	defer b.RUnlock()
+	parent, exists := b.index[hashKey(block.PreviousHash)]
+	if exists && block.Difficulty != b.nextDifficulty(parent) {
+		return ErrWrongDifficulty
+	}
+	return nil
+}
+
+// FindTransaction returns the block on the main chain which includes the transaction with the given ID
+// along with the index of the transaction within the block
+func (b *Blockchain) FindTransaction(id string) (Block, int, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	location, exists := b.included[id]
+	if !exists {
+		return Block{}, 0, false
+	}
+	return *b.blocks[location.block], location.index, true
+}
+
+// BlockByHash returns a known block with the given hash, whether it is on the main chain or not
+func (b *Blockchain) BlockByHash(hash []byte) (*Block, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	link, exists := b.index[hashKey(hash)]
+	if !exists {
+		return nil, false
+	}
+	return link.block, true
+}
+
+func hashKey(hash []byte) string {
+	return base64.StdEncoding.EncodeToString(hash)
 }
 
+// loadStore adds the blocks from the store to the blockchain, validating each of them again
+func (b *Blockchain) loadStore() {
+	blocks, err := b.store.Blocks()
+	if err != nil {
+		log.Fatalf("Failed to read blocks from store: %v\n", err)
+	}
+	for _, block := range blocks {
+		if err := b.insertBlock(block, false); err != nil {
+			log.Printf("Discarding invalid stored block %v: %v\n", block, err)
+		}
+	}
+	log.Printf("Loaded %d blocks from store, last block: %v\n", len(blocks), b.lastBlock())
+}
+
+// Account returns the current state of the account matching the address
+func (b *Blockchain) Account(address ed25519.PublicKey) (Account, error) {
+	b.RLock()
+	defer b.RUnlock()
+	account, err := b.accounts.Read(address)
+	if err != nil {
+		return Account{}, err
+	}
+	return *account, nil
+}
+
+// ListAccounts returns the current state of all accounts
+func (b *Blockchain) ListAccounts() []Account {
+	b.RLock()
+	defer b.RUnlock()
+	return b.accounts.ListAccounts()
+}
+
+// Work returns the cumulative work of the main chain
+func (b *Blockchain) Work() uint64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.tip().work
+}
+
+// Tip returns the last block of the main chain along with the cumulative work of the main chain
+func (b *Blockchain) Tip() (*Block, uint64) {
+	b.RLock()
+	defer b.RUnlock()
+	tip := b.tip()
+	return tip.block, tip.work
+}
+
+// AddBlock adds a block received from another node and makes the miner switch to the new tip if the
+// block changed the main chain
+func (b *Blockchain) AddBlock(block *Block) error {
+	if err := b.addBlock(block); err != nil {
+		return err
+	}
+	select {
+	case b.tipUpdates <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+var (
+	// ErrOrphanBlock is returned for blocks whose parent is not known, e.g. because the node is behind
+	ErrOrphanBlock = errors.New("New block has an unknown parent")
+	// ErrWrongDifficulty is returned for blocks whose difficulty is not the one expected after their parent
+	ErrWrongDifficulty = errors.New("New block has wrong difficulty")
+	// ErrInvalidBlock is returned, possibly wrapped, for blocks which break the rules of the blockchain.
+	// Blocks which could not be added because of a local failure, e.g. a storage error, are not invalid.
+	ErrInvalidBlock = errors.New("New block is not valid")
+)
+
+// addBlock adds a block to the known block tree and reorganizes the main chain onto its
+// branch if the branch has more cumulative work than the current main chain
 func (b *Blockchain) addBlock(block *Block) error {
-	previous := b.LastBlock()
-	if previous == nil {
+	b.Lock()
+	defer b.Unlock()
+	return b.insertBlock(block, true)
+}
+
+func (b *Blockchain) insertBlock(block *Block, persist bool) error {
+	if _, exists := b.index[hashKey(block.Hash)]; exists {
+		return nil
+	}
+	link := &chainLink{block: block, work: block.Work()}
+	tip := b.tip()
+	// The genesis block is fixed so it is never persisted
+	if tip == nil {
 		log.Printf("Adding genesis block: %+v\n", block)
-		b.blocks = append(b.blocks, block)
+		b.index[hashKey(block.Hash)] = link
+		b.connect(link, b.accounts.Snapshot())
 		return nil
 	}
-	if !block.IsValid(previous) {
-		return errors.New("New block is not valid")
+	parent, exists := b.index[hashKey(block.PreviousHash)]
+	if !exists {
+		return ErrOrphanBlock
+	}
+	if !block.IsValid(parent.block) {
+		return ErrInvalidBlock
+	}
+	if block.Difficulty != b.nextDifficulty(parent) {
+		return ErrWrongDifficulty
+	}
+	accounts, err := b.accountsAt(parent)
+	if err != nil {
+		return fmt.Errorf("%w: it is on an invalid branch: %v", ErrInvalidBlock, err)
+	}
+	if err := accounts.ApplyBlock(block); err != nil {
+		return fmt.Errorf("%w: it has invalid transactions: %v", ErrInvalidBlock, err)
+	}
+	if err := b.persist(block, persist); err != nil {
+		return err
+	}
+	link.parent = parent
+	link.work += parent.work
+	b.index[hashKey(block.Hash)] = link
+
+	if link.work <= tip.work {
+		log.Println("Added block to side branch:", block)
+		return nil
+	}
+	if parent != tip {
+		b.reorganize(link)
+		return nil
+	}
+	b.connect(link, accounts)
+	return nil
+}
+
+func (b *Blockchain) persist(block *Block, persist bool) error {
+	if b.store == nil || !persist {
+		return nil
+	}
+	if err := b.store.Append(block); err != nil {
+		return fmt.Errorf("Failed to store block: %v", err)
+	}
+	return nil
+}
+
+// accountsAt returns a snapshot of the account states after the block of the given link has been
+// applied. The main chain is rolled back to where the branch of the link forks off and the branch is
+// applied from there, so the cost depends on the length of the branch rather than the whole chain.
+func (b *Blockchain) accountsAt(link *chainLink) (*Accounts, error) {
+	accounts := b.accounts.Snapshot()
+	branch := []*Block{}
+	for ; !b.onMainChain(link.block); link = link.parent {
+		branch = append([]*Block{link.block}, branch...)
+	}
+	for number := len(b.blocks) - 1; number > link.block.Number; number-- {
+		accounts.revert(b.index[hashKey(b.blocks[number].Hash)].undo)
+	}
+	for _, block := range branch {
+		if err := accounts.ApplyBlock(block); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+// connect appends the block of the link to the main chain. The given accounts need to be a snapshot of
+// the current accounts with the block already applied.
+func (b *Blockchain) connect(link *chainLink, accounts *Accounts) {
+	link.undo = b.accounts.merge(accounts)
+	b.blocks = append(b.blocks, link.block)
+	for index, transaction := range link.block.Transactions {
+		b.included[transaction.ID()] = transactionLocation{link.block.Number, index}
+	}
+	if b.archive != nil {
+		b.archive.connect(link.block, b.accounts, link.undo)
+	}
+	b.removeFromPool(link.block)
+}
+
+// disconnect removes the last block from the main chain and rolls back the account states
+func (b *Blockchain) disconnect() {
+	link := b.tip()
+	b.accounts.revert(link.undo)
+	link.undo = nil
+	b.blocks = b.blocks[:len(b.blocks)-1]
+	for _, transaction := range link.block.Transactions {
+		delete(b.included, transaction.ID())
+	}
+	if b.archive != nil {
+		b.archive.disconnect(link.block)
+	}
+	b.returnToPool(link.block)
+}
+
+func (b *Blockchain) tip() *chainLink {
+	if last := b.lastBlock(); last != nil {
+		return b.index[hashKey(last.Hash)]
 	}
-	b.blocks = append(b.blocks, block)
 	return nil
 }
 
+// reorganize switches the main chain over to the branch ending at the given link. Transactions
+// from the blocks which drop off the main chain are returned to the pool.
+func (b *Blockchain) reorganize(newTip *chainLink) {
+	branch := []*chainLink{}
+	link := newTip
+	for !b.onMainChain(link.block) {
+		branch = append([]*chainLink{link}, branch...)
+		link = link.parent
+	}
+	fork := link.block.Number
+
+	orphaned := len(b.blocks) - fork - 1
+	for len(b.blocks) > fork+1 {
+		b.disconnect()
+	}
+	for _, link := range branch {
+		accounts := b.accounts.Snapshot()
+		if err := accounts.ApplyBlock(link.block); err != nil {
+			log.Fatalf("Failed to apply previously validated block %v: %v\n", link.block, err)
+		}
+		b.connect(link, accounts)
+	}
+	log.Printf("Reorganized chain after block %d: %d blocks orphaned, %d blocks added\n", fork, orphaned, len(branch))
+}
+
+func (b *Blockchain) onMainChain(block *Block) bool {
+	return block.Number < len(b.blocks) && b.blocks[block.Number] == block
+}
+
+// ErrKnownTransaction is returned when a submitted transaction is already in the pool
+var ErrKnownTransaction = errors.New("Transaction is already known")
+
 // AddTransaction adds transaction to the pool of available transactions to include in next block
 func (b *Blockchain) AddTransaction(transaction Transaction) error {
 	if !transaction.ValidSignature() {
-		return errors.New("Transaction has invalid signature")
+		return ErrInvalidSignature
+	}
+	b.Lock()
+	defer b.Unlock()
+	if err := b.addToPool(transaction); err != nil {
+		return err
 	}
-	b.pool[base64.StdEncoding.EncodeToString(transaction.Signature)] = transaction
+	b.notifyPoolUpdate()
 	return nil
 }
 
+// SubmitTransaction validates the transaction against the current account states and adds it to the
+// pool. ErrKnownTransaction is returned if the transaction is already in the pool. A transaction with the
+// same sender and nonce as a pooled transaction replaces it if it pays a high enough fee. The balance of
+// the sender needs to cover all of its pooled transactions. The reasons for
+// rejecting validly signed transactions are kept for TransactionStatus.
+func (b *Blockchain) SubmitTransaction(transaction Transaction) error {
+	if transaction.Sender == nil {
+		return errors.New("Coinbase transactions can not be submitted")
+	}
+	if !transaction.ValidSignature() {
+		return ErrInvalidSignature
+	}
+	cost, err := transaction.Cost()
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if err := b.submitTransaction(transaction, cost); err != nil {
+		if err != ErrKnownTransaction {
+			b.pool.reject(transaction.ID(), err.Error())
+		}
+		return err
+	}
+	b.notifyPoolUpdate()
+	return nil
+}
+
+func (b *Blockchain) submitTransaction(transaction Transaction, cost uint) error {
+	if b.pool.contains(transaction) {
+		return ErrKnownTransaction
+	}
+	account, err := b.accounts.Read(transaction.Sender)
+	if err != nil {
+		return err
+	}
+	if transaction.Nonce <= account.Nonce {
+		return errors.New("Transaction nonce has already been used")
+	}
+	if cost > account.Balance {
+		return errors.New("Account has insufficient balance")
+	}
+	// The balance also needs to cover the other transactions of the sender in the pool, since they could
+	// all be mined. A transaction being replaced does not count.
+	remaining := account.Balance
+	for _, pooled := range b.pool.transactionsOf(transaction.Sender) {
+		if pooled.Nonce == transaction.Nonce {
+			continue
+		}
+		pooledCost, _ := pooled.Cost()
+		if pooledCost > remaining {
+			remaining = 0
+			break
+		}
+		remaining -= pooledCost
+	}
+	if cost > remaining {
+		return errors.New("Account has insufficient balance for its pooled transactions")
+	}
+	return b.addToPool(transaction)
+}
+
+// addToPool expires old transactions from the pool and adds the transaction to it
+func (b *Blockchain) addToPool(transaction Transaction) error {
+	var nonce uint
+	if account, err := b.accounts.Read(transaction.Sender); err == nil {
+		nonce = account.Nonce
+	}
+	now := time.Now()
+	b.pool.expire(now)
+	return b.pool.add(transaction, nonce, now)
+}
+
+// notifyPoolUpdate tells the miner that the pool has changed without waiting for it to notice
+func (b *Blockchain) notifyPoolUpdate() {
+	select {
+	case b.poolUpdates <- struct{}{}:
+	default:
+	}
+}
+
+// filterValidTransactions selects the transactions for the next block among the pending transactions in
+// the pool. Queued transactions are left out until the gap in the nonces of their sender is filled.
 func (b *Blockchain) filterValidTransactions() []Transaction {
 	validTransactions := make([]Transaction, 0)
-	accounts := AccountsFromBlockchain(b.blocks)
-	for _, transaction := range b.pool {
+	accounts := b.accounts.Snapshot()
+	for _, transaction := range prioritizeByFee(b.pool.pending()) {
 		if err := accounts.ApplyTransaction(transaction); err != nil {
 			log.Println("Transaction is invalid", err)
 			continue
@@ -85,76 +542,152 @@ func (b *Blockchain) filterValidTransactions() []Transaction {
 	return validTransactions
 }
 
-func (b *Blockchain) clearSpentTransactions() {
-	for _, transaction := range b.LastBlock().Transactions {
-		delete(b.pool, base64.StdEncoding.EncodeToString(transaction.Signature))
+// removeFromPool removes the transactions of a block added to the main chain from the pool along with
+// the transactions whose nonces the block used up
+func (b *Blockchain) removeFromPool(block *Block) {
+	for _, transaction := range block.Transactions {
+		if transaction.IsCoinbase() {
+			continue
+		}
+		b.pool.remove(transaction)
+		if account, err := b.accounts.Read(transaction.Sender); err == nil {
+			b.pool.update(transaction.Sender, account.Nonce)
+		}
+	}
+	b.pool.expire(time.Now())
+}
+
+// returnToPool returns the transactions of a block removed from the main chain to the pool
+func (b *Blockchain) returnToPool(block *Block) {
+	for _, transaction := range block.Transactions {
+		if transaction.IsCoinbase() {
+			continue
+		}
+		if err := b.addToPool(transaction); err != nil {
+			log.Printf("Failed to return transaction to pool: %v\n", err)
+		}
 	}
 }
 
 func (b *Blockchain) transactionsForNextBlock() []Transaction {
-	return append([]Transaction{CoinbaseTransactionTo(b.keyPair.PublicKey)}, b.filterValidTransactions()...)
+	transactions := b.filterValidTransactions()
+	var fees uint
+	for _, transaction := range transactions {
+		fees += transaction.Fee
+	}
+	return append([]Transaction{CoinbaseTransactionTo(b.keyPair.PublicKey, fees)}, transactions...)
 }
 
-// ProofOfWorkRequest is a request to mine a new block
-type ProofOfWorkRequest struct {
-	blockNumber       int
-	previousBlock     Block
-	blockTransactions []Transaction
+// Hashrate returns the number of hashes per second computed while mining the previous block
+func (b *Blockchain) Hashrate() float64 {
+	return b.miner.Hashrate()
 }
 
-func blockWorker(nonces <-chan int, validBlock chan<- Block, request ProofOfWorkRequest) {
-	for {
-		nonce, more := <-nonces
-		if !more {
-			return
+// blockTemplate returns an unmined block on top of the main chain with the best transactions from the pool
+func (b *Blockchain) blockTemplate() *Block {
+	previous := b.lastBlock()
+	template := NewBlock(previous.Number+1, previous.Hash, b.nextDifficulty(b.tip()), b.transactionsForNextBlock(), 0)
+	// Blocks may be timestamped ahead of the local clock, but a block can never be older than its parent
+	if template.Time.Before(previous.Time) {
+		template.Time = previous.Time
+		template.Hash = template.ComputeHash()
+	}
+	return template
+}
+
+// refreshTemplate returns a new block template if the transactions selected from the pool differ from the
+// ones in the current template
+func (b *Blockchain) refreshTemplate(current *Block) (*Block, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	template := b.blockTemplate()
+	if len(template.Transactions) == len(current.Transactions) {
+		// The coinbase transaction is skipped since its timestamp differs between templates
+		same := true
+		for i := 1; i < len(template.Transactions) && same; i++ {
+			same = bytes.Equal(template.Transactions[i].Signature, current.Transactions[i].Signature)
 		}
-		block := NewBlock(request.blockNumber, request.previousBlock.Hash, request.blockTransactions, nonce)
-		if block.IsValid(&request.previousBlock) {
-			validBlock <- *block
-			return
+		if same {
+			return current, false
 		}
 	}
+	return template, true
 }
 
-// MineBlock mines a new valid block with transactions from the mempool
-func (b *Blockchain) MineBlock() Block {
-	nonces := make(chan int)
-	validBlock := make(chan Block, runtime.NumCPU())
-
-	defer func() {
-		close(nonces)
-		b.clearSpentTransactions()
+// startMining starts mining the template in the background and returns a function which stops it. The
+// block is sent to mined unless mining was stopped before a valid nonce was found.
+func (b *Blockchain) startMining(ctx context.Context, template *Block, mined chan<- Block) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		header, ok := b.miner.Mine(ctx, template.Header())
+		if !ok {
+			return
+		}
+		block := *template
+		block.Time = header.Time
+		block.Nonce = header.Nonce
+		block.Hash = header.Hash()
+		select {
+		case mined <- block:
+		default:
+		}
 	}()
+	return cancel
+}
 
-	// Create a worker per core to mine for a valid block
-	for worker := 0; worker < runtime.NumCPU(); worker++ {
-		go blockWorker(nonces, validBlock, ProofOfWorkRequest{b.LastBlock().Number + 1, *b.LastBlock(), b.transactionsForNextBlock()})
-	}
+// MineBlock mines a new valid block with transactions from the mempool. The block being mined is rebuilt
+// when the transactions in the pool change and periodically after the template refresh interval.
+func (b *Blockchain) MineBlock() Block {
+	b.RLock()
+	template := b.blockTemplate()
+	b.RUnlock()
 
-	// Send incremental nonces to workers until a valid block is found
-	for nonce := 0; nonce < math.MaxInt64; nonce++ {
+	// Blocks mined from earlier templates are still valid as long as the tip has not changed, so all
+	// templates share the same channel
+	mined := make(chan Block, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := b.startMining(ctx, template, mined)
+	refresh := time.NewTicker(b.templateRefreshInterval)
+	defer refresh.Stop()
+
+	for {
 		select {
-		// Another node found a valid block
-		case block := <-b.externalBlocks:
-			if block.IsValid(b.LastBlock()) {
-				if err := b.addBlock(&block); err != nil {
-					log.Fatalf("Failed to add external block to blockchain: %v\n", err)
-				}
-				log.Println("Remote node found valid block:", block)
-				return *b.LastBlock()
+		// Transactions were added to the pool
+		case <-b.poolUpdates:
+			updated, changed := b.refreshTemplate(template)
+			if !changed {
+				continue
+			}
+			stop()
+			template = updated
+			stop = b.startMining(ctx, template, mined)
+		// Rebuild the template so that its timestamp does not fall behind
+		case <-refresh.C:
+			stop()
+			b.RLock()
+			template = b.blockTemplate()
+			b.RUnlock()
+			stop = b.startMining(ctx, template, mined)
+		// A block was added outside of the miner, e.g. received from another node or while syncing
+		case <-b.tipUpdates:
+			if tip := b.LastBlock(); !bytes.Equal(tip.Hash, template.PreviousHash) {
+				log.Println("Switching to new tip:", tip)
+				return *tip
 			}
 		// Found a valid block
-		case block := <-validBlock:
+		case block := <-mined:
 			if err := b.addBlock(&block); err != nil {
-				log.Fatalf("Failed to add internally generated block to blockchain: %v\n", err)
+				log.Printf("Rejected internally generated block %v: %v\n", block, err)
+				stop()
+				b.RLock()
+				template = b.blockTemplate()
+				b.RUnlock()
+				stop = b.startMining(ctx, template, mined)
+				continue
 			}
-			log.Printf("🎉 Found valid block: %+v\n", block)
+			log.Printf("🎉 Found valid block at %.0f hashes per second: %+v\n", b.Hashrate(), block)
 			return *b.LastBlock()
-		// No valid block found yet so keep sending nonces to workers
-		default:
-			nonces <- nonce
 		}
 	}
-
-	panic("Exhausted possible nonce values")
 }
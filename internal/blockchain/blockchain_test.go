@@ -2,8 +2,10 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/coocos/cryptocurrency/internal/keys"
 )
@@ -48,7 +50,7 @@ func TestBlockChain(t *testing.T) {
 		chain.MineBlock()
 
 		// Mine next block to send coins from miner to receiver
-		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 1)
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 0, 1)
 		transaction.Sign(miner.PrivateKey)
 		if err := chain.AddTransaction(*transaction); err != nil {
 			t.Errorf("Failed to add transaction to blockchain: %v", err)
@@ -67,7 +69,7 @@ func TestBlockChain(t *testing.T) {
 		chain.MineBlock()
 
 		// Mine next block to send coins from miner to receiver
-		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 15, 1)
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 15, 0, 1)
 		transaction.Sign(miner.PrivateKey)
 		if err := chain.AddTransaction(*transaction); err != nil {
 			t.Errorf("Failed to add transaction to blockchain: %v", err)
@@ -83,7 +85,7 @@ func TestBlockChain(t *testing.T) {
 		chain.MineBlock()
 
 		// Mine next block to send coins from miner to receiver
-		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 1)
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 0, 1)
 		transaction.Sign(miner.PrivateKey)
 		if err := chain.AddTransaction(*transaction); err != nil {
 			t.Errorf("Failed to add transaction to blockchain: %v", err)
@@ -101,11 +103,260 @@ func TestBlockChain(t *testing.T) {
 		secondChain := NewBlockchain(miner)
 
 		firstBlock := firstChain.MineBlock()
-		secondChain.SubmitExternalBlock(firstChain.LastBlock())
-		secondBlock := secondChain.MineBlock()
+		if err := secondChain.AddBlock(firstChain.LastBlock()); err != nil {
+			t.Fatal(err)
+		}
 
-		if !reflect.DeepEqual(firstBlock, secondBlock) {
+		if !reflect.DeepEqual(firstBlock, *secondChain.LastBlock()) {
 			t.Error("Blockchain did not accept block from other chain")
 		}
 	})
+	t.Run("Test that blockchain reorganizes onto branch with more work", func(t *testing.T) {
+		firstChain := NewBlockchain(miner, withTestGenesis())
+		secondChain := NewBlockchain(miner, withTestGenesis())
+
+		// Spend coins from the first block of the first chain in its second block
+		firstChain.MineBlock()
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 0, 1)
+		transaction.Sign(miner.PrivateKey)
+		firstChain.AddTransaction(*transaction)
+		firstChain.MineBlock()
+
+		// Mine a longer competing chain and feed it to the first chain
+		for i := 0; i < 3; i++ {
+			secondChain.MineBlock()
+		}
+		for _, block := range secondChain.blocks[1:3] {
+			if err := firstChain.addBlock(block); err != nil {
+				t.Fatalf("Failed to add competing block: %v", err)
+			}
+			if bytes.Equal(firstChain.LastBlock().Hash, block.Hash) {
+				t.Fatal("Blockchain reorganized onto branch without more work")
+			}
+		}
+		if err := firstChain.addBlock(secondChain.LastBlock()); err != nil {
+			t.Fatalf("Failed to add competing block: %v", err)
+		}
+
+		if !reflect.DeepEqual(firstChain.blocks, secondChain.blocks) {
+			t.Error("Blockchain did not reorganize onto branch with more work")
+		}
+		if !firstChain.pool.contains(*transaction) {
+			t.Error("Orphaned transaction was not returned to the pool")
+		}
+		accounts, _ := AccountsFromBlockchain(firstChain.blocks)
+		if _, err := accounts.Read(receiver.PublicKey); err == nil {
+			t.Error("Orphaned transaction is still reflected in account state")
+		}
+		if _, err := firstChain.Account(receiver.PublicKey); err == nil {
+			t.Error("Orphaned transaction was not rolled back from account state")
+		}
+		account, _ := firstChain.Account(miner.PublicKey)
+		if account.Balance != 3*CoinbaseTransactionAmount || account.Nonce != 0 {
+			t.Errorf("Expected miner balance %d and nonce %d but got %d and %d\n", 3*CoinbaseTransactionAmount, 0, account.Balance, account.Nonce)
+		}
+	})
+	t.Run("Test mining on top of block timestamped in the future", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		future := chain.blockTemplate()
+		future.Time = time.Now().Add(time.Hour).UTC()
+		header, _ := chain.miner.Mine(context.Background(), future.Header())
+		future.Time = header.Time
+		future.Nonce = header.Nonce
+		future.Hash = header.Hash()
+		if err := chain.AddBlock(future); err != nil {
+			t.Fatalf("Failed to add block timestamped in the future: %v", err)
+		}
+
+		block := chain.MineBlock()
+		if block.Number != 2 || block.Time.Before(future.Time) {
+			t.Errorf("Mined block %v is older than its parent", block)
+		}
+	})
+	t.Run("Test that difficulty is retargeted after a fast period", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		for chain.LastBlock().Number < 2*RetargetInterval-1 {
+			chain.MineBlock()
+		}
+
+		block := chain.MineBlock()
+		if block.Difficulty != maxRetargetFactor {
+			t.Errorf("Expected difficulty %d but got %d\n", maxRetargetFactor, block.Difficulty)
+		}
+	})
+	t.Run("Test that block with wrong difficulty is rejected", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		genesis := chain.LastBlock()
+
+		block := NewBlock(genesis.Number+1, genesis.Hash, 2, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0)}, 0)
+		for !block.MeetsDifficulty() {
+			block = NewBlock(block.Number, block.PreviousHash, block.Difficulty, block.Transactions, block.Nonce+1)
+		}
+		if err := chain.addBlock(block); err == nil {
+			t.Error("Block with wrong difficulty was accepted")
+		}
+	})
+	t.Run("Test that coinbase transaction collects transaction fees", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		chain.MineBlock()
+
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 2, 1)
+		transaction.Sign(miner.PrivateKey)
+		chain.AddTransaction(*transaction)
+		block := chain.MineBlock()
+
+		if coinbase := block.Transactions[0]; coinbase.Amount != CoinbaseTransactionAmount+2 {
+			t.Errorf("Expected coinbase amount %d but got %d\n", CoinbaseTransactionAmount+2, coinbase.Amount)
+		}
+	})
+	t.Run("Test that block with overspent transaction is rejected", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		chain.MineBlock()
+		parent := chain.LastBlock()
+
+		// The coinbase of the new block is credited before the transaction is applied
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 25, 0, 1)
+		transaction.Sign(miner.PrivateKey)
+		block := NewBlock(parent.Number+1, parent.Hash, parent.Difficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0), *transaction}, 0)
+
+		if err := chain.addBlock(block); err == nil {
+			t.Error("Block with overspent transaction was accepted")
+		}
+		if chain.LastBlock() != parent {
+			t.Error("Blockchain switched to block with overspent transaction")
+		}
+	})
+	t.Run("Test that block with invalid signature is rejected", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		chain.MineBlock()
+		parent := chain.LastBlock()
+
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 0, 1)
+		transaction.Sign(receiver.PrivateKey)
+		block := NewBlock(parent.Number+1, parent.Hash, parent.Difficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0), *transaction}, 0)
+
+		if err := chain.addBlock(block); err == nil {
+			t.Error("Block with invalid signature was accepted")
+		}
+	})
+	t.Run("Test that blockchain is restored from store", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := OpenFileStore(dir)
+		if err != nil {
+			t.Fatal("Failed to open store:", err)
+		}
+		chain := NewBlockchain(miner, withTestGenesis(), WithStore(store))
+		chain.MineBlock()
+		chain.MineBlock()
+		store.Close()
+
+		store, err = OpenFileStore(dir)
+		if err != nil {
+			t.Fatal("Failed to reopen store:", err)
+		}
+		defer store.Close()
+		restored := NewBlockchain(miner, withTestGenesis(), WithStore(store))
+		if !bytes.Equal(restored.LastBlock().Hash, chain.LastBlock().Hash) {
+			t.Error("Restored blockchain does not match stored blockchain")
+		}
+	})
+	t.Run("Test that submitted transactions are validated against account state", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		chain.MineBlock()
+
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 5, 1, 1)
+		transaction.Sign(miner.PrivateKey)
+		if err := chain.SubmitTransaction(*transaction); err != nil {
+			t.Fatalf("Failed to submit valid transaction: %v", err)
+		}
+		if err := chain.SubmitTransaction(*transaction); err != ErrKnownTransaction {
+			t.Errorf("Expected resubmitted transaction to be known but got %v", err)
+		}
+
+		overspent := NewTransaction(miner.PublicKey, receiver.PublicKey, 10, 1, 2)
+		overspent.Sign(miner.PrivateKey)
+		usedNonce := NewTransaction(miner.PublicKey, receiver.PublicKey, 1, 0, 0)
+		usedNonce.Sign(miner.PrivateKey)
+		unknownSender := NewTransaction(receiver.PublicKey, miner.PublicKey, 1, 0, 1)
+		unknownSender.Sign(receiver.PrivateKey)
+		for _, invalid := range []*Transaction{overspent, usedNonce, unknownSender} {
+			if err := chain.SubmitTransaction(*invalid); err == nil {
+				t.Errorf("Invalid transaction was accepted: %v", invalid)
+			}
+		}
+	})
+	t.Run("Test refreshing block template when pool changes", func(t *testing.T) {
+		chain := NewBlockchain(miner, withTestGenesis())
+		chain.MineBlock()
+		template := chain.blockTemplate()
+		if _, changed := chain.refreshTemplate(template); changed {
+			t.Error("Template changed although pool did not change")
+		}
+
+		transaction := NewTransaction(miner.PublicKey, receiver.PublicKey, 1, 1, 1)
+		transaction.Sign(miner.PrivateKey)
+		if err := chain.SubmitTransaction(*transaction); err != nil {
+			t.Fatalf("Failed to submit transaction: %v", err)
+		}
+		select {
+		case <-chain.poolUpdates:
+		default:
+			t.Error("Submitting transaction did not notify miner")
+		}
+		updated, changed := chain.refreshTemplate(template)
+		if !changed || len(updated.Transactions) != 2 || !bytes.Equal(updated.Transactions[1].Signature, transaction.Signature) {
+			t.Error("Template was not refreshed with new transaction")
+		}
+		if updated.Transactions[0].Amount != CoinbaseTransactionAmount+1 {
+			t.Error("Refreshed template does not collect fee of new transaction")
+		}
+		if _, changed := chain.refreshTemplate(updated); changed {
+			t.Error("Template changed again although pool did not change")
+		}
+	})
+	t.Run("Test validating header chain", func(t *testing.T) {
+		source := NewBlockchain(miner, withTestGenesis())
+		headers := []BlockHeader{}
+		for i := 0; i < 3; i++ {
+			block := source.MineBlock()
+			headers = append(headers, block.Header())
+		}
+
+		chain := NewBlockchain(nil, withTestGenesis())
+		if _, err := chain.NewHeaderChain(headers[1].PreviousHash); err != ErrUnknownParent {
+			t.Errorf("Expected unknown parent but got %v", err)
+		}
+		headerChain, err := chain.NewHeaderChain(headers[0].PreviousHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := headerChain.Append(headers); err != nil {
+			t.Fatalf("Failed to append valid headers: %v", err)
+		}
+		tip := headerChain.Tip()
+		if headerChain.Work() != source.Work() || !bytes.Equal(tip.Hash(), source.LastBlock().Hash) {
+			t.Error("Header chain does not match source chain")
+		}
+
+		tampered := headers[1]
+		tampered.Difficulty += 1
+		invalid, _ := chain.NewHeaderChain(headers[0].PreviousHash)
+		if err := invalid.Append([]BlockHeader{headers[0], tampered}); err == nil {
+			t.Error("Header with wrong difficulty was appended")
+		}
+		if err := invalid.Append(headers[2:]); err == nil {
+			t.Error("Header which does not link to previous header was appended")
+		}
+	})
+}
+
+// withTestGenesis starts the blockchain from a genesis block with the lowest possible difficulty
+func withTestGenesis() Option {
+	genesis := &Block{
+		Number:     0,
+		Time:       time.Date(2021, time.May, 1, 6, 0, 0, 0, time.UTC),
+		Difficulty: minDifficulty,
+	}
+	genesis.Hash = genesis.ComputeHash()
+	return WithGenesis(genesis)
 }
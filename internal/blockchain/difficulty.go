@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	// RetargetInterval is the number of blocks between difficulty adjustments
+	RetargetInterval = 20
+	// DefaultTargetBlockInterval is the average time between blocks the difficulty is adjusted towards
+	DefaultTargetBlockInterval = 15 * time.Second
+	// maxRetargetFactor limits how much the difficulty can change in a single adjustment
+	maxRetargetFactor = 4
+	minDifficulty     = 1
+)
+
+// retargetDifficulty returns the difficulty for the block following parent, given the block preceding
+// the retargeting period which ends at parent. The difficulty is scaled by how much faster or slower the
+// period was mined compared to the target block interval.
+func retargetDifficulty(parent *Block, first *Block, targetBlockInterval time.Duration) uint64 {
+	expected := int64(targetBlockInterval) * RetargetInterval
+	actual := int64(parent.Time.Sub(first.Time))
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	difficulty := new(big.Int).SetUint64(parent.Difficulty)
+	difficulty.Mul(difficulty, big.NewInt(expected))
+	difficulty.Div(difficulty, big.NewInt(actual))
+	if !difficulty.IsUint64() {
+		return parent.Difficulty
+	}
+	if difficulty.Uint64() < minDifficulty {
+		return minDifficulty
+	}
+	return difficulty.Uint64()
+}
+
+// nextDifficulty returns the difficulty required for the block following the given link
+func (b *Blockchain) nextDifficulty(parent *chainLink) uint64 {
+	// The first period is never retargeted since the genesis block predates the rest of the chain
+	number := parent.block.Number + 1
+	if number%RetargetInterval != 0 || number <= RetargetInterval {
+		return parent.block.Difficulty
+	}
+	// Find the last block of the previous period on the same branch as the parent
+	first := parent
+	for i := 0; i < RetargetInterval; i++ {
+		first = first.parent
+	}
+	return retargetDifficulty(parent.block, first.block, b.targetBlockInterval)
+}
@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDifficulty(t *testing.T) {
+	first := &Block{Time: time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC), Difficulty: 1000}
+	period := DefaultTargetBlockInterval * RetargetInterval
+
+	t.Run("Test that difficulty is unchanged when blocks are on target", func(t *testing.T) {
+		parent := &Block{Time: first.Time.Add(period), Difficulty: 1000}
+		if difficulty := retargetDifficulty(parent, first, DefaultTargetBlockInterval); difficulty != 1000 {
+			t.Errorf("Expected difficulty %d but got %d\n", 1000, difficulty)
+		}
+	})
+	t.Run("Test that difficulty increases when blocks are too fast", func(t *testing.T) {
+		parent := &Block{Time: first.Time.Add(period / 2), Difficulty: 1000}
+		if difficulty := retargetDifficulty(parent, first, DefaultTargetBlockInterval); difficulty != 2000 {
+			t.Errorf("Expected difficulty %d but got %d\n", 2000, difficulty)
+		}
+	})
+	t.Run("Test that difficulty decreases when blocks are too slow", func(t *testing.T) {
+		parent := &Block{Time: first.Time.Add(period * 2), Difficulty: 1000}
+		if difficulty := retargetDifficulty(parent, first, DefaultTargetBlockInterval); difficulty != 500 {
+			t.Errorf("Expected difficulty %d but got %d\n", 500, difficulty)
+		}
+	})
+	t.Run("Test that difficulty adjustment is limited", func(t *testing.T) {
+		parent := &Block{Time: first.Time, Difficulty: 1000}
+		if difficulty := retargetDifficulty(parent, first, DefaultTargetBlockInterval); difficulty != 4000 {
+			t.Errorf("Expected difficulty %d but got %d\n", 4000, difficulty)
+		}
+		parent = &Block{Time: first.Time.Add(period * 100), Difficulty: 1000}
+		if difficulty := retargetDifficulty(parent, first, DefaultTargetBlockInterval); difficulty != 250 {
+			t.Errorf("Expected difficulty %d but got %d\n", 250, difficulty)
+		}
+	})
+}
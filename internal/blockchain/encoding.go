@@ -0,0 +1,246 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EncodingVersion is the version of the binary encoding of transactions, block headers and blocks. The
+// encoding is used for signing and hashing, so changing it is a consensus change.
+//
+// Every encoding starts with the version byte followed by the fields of the value in a fixed order:
+//
+//	integers      unsigned 64-bit big-endian, block nonces as 64-bit two's complement
+//	byte strings  unsigned 32-bit big-endian length followed by the bytes, nil is encoded as length 0
+//	times         signed 64-bit big-endian seconds since the Unix epoch followed by unsigned
+//	              32-bit big-endian nanoseconds
+//
+// Transactions are encoded as sender, receiver, amount, fee, nonce, time and signature. The signing payload
+// of a transaction is the same encoding without the signature. Block headers are encoded as number, time,
+// difficulty, previous hash, Merkle root and nonce. Blocks are encoded as the header, followed by the number
+// of transactions as an unsigned 32-bit big-endian integer and the transactions without their version bytes.
+const EncodingVersion = 1
+
+// encoder appends values to a buffer using the binary encoding
+type encoder struct {
+	buffer []byte
+}
+
+func newEncoder() *encoder {
+	return &encoder{[]byte{EncodingVersion}}
+}
+
+func (e *encoder) uint32(value uint32) {
+	var encoded [4]byte
+	binary.BigEndian.PutUint32(encoded[:], value)
+	e.buffer = append(e.buffer, encoded[:]...)
+}
+
+func (e *encoder) uint64(value uint64) {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], value)
+	e.buffer = append(e.buffer, encoded[:]...)
+}
+
+func (e *encoder) bytes(value []byte) {
+	e.uint32(uint32(len(value)))
+	e.buffer = append(e.buffer, value...)
+}
+
+func (e *encoder) time(value time.Time) {
+	e.uint64(uint64(value.Unix()))
+	e.uint32(uint32(value.Nanosecond()))
+}
+
+func (e *encoder) unsignedTransaction(t *Transaction) {
+	e.bytes(t.Sender)
+	e.bytes(t.Receiver)
+	e.uint64(uint64(t.Amount))
+	e.uint64(uint64(t.Fee))
+	e.uint64(uint64(t.Nonce))
+	e.time(t.Time)
+}
+
+func (e *encoder) transaction(t *Transaction) {
+	e.unsignedTransaction(t)
+	e.bytes(t.Signature)
+}
+
+func (e *encoder) header(h *BlockHeader) {
+	e.uint64(uint64(h.Number))
+	e.time(h.Time)
+	e.uint64(h.Difficulty)
+	e.bytes(h.PreviousHash)
+	e.bytes(h.MerkleRoot)
+	e.uint64(uint64(h.Nonce))
+}
+
+// decoder reads values encoded by encoder. The first error is kept and all reads after it are no-ops,
+// so the error only needs to be checked once all the fields have been read.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func newDecoder(data []byte) *decoder {
+	d := &decoder{data: data}
+	if len(data) == 0 {
+		d.err = errors.New("Encoding is empty")
+	} else if data[0] != EncodingVersion {
+		d.err = fmt.Errorf("Unsupported encoding version %d", data[0])
+	} else {
+		d.data = data[1:]
+	}
+	return d
+}
+
+func (d *decoder) next(size uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < size {
+		d.err = errors.New("Encoding is truncated")
+		return nil
+	}
+	value := d.data[:size]
+	d.data = d.data[size:]
+	return value
+}
+
+func (d *decoder) uint32() uint32 {
+	value := d.next(4)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(value)
+}
+
+func (d *decoder) uint64() uint64 {
+	value := d.next(8)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+func (d *decoder) bytes() []byte {
+	length := d.uint32()
+	value := d.next(uint64(length))
+	if len(value) == 0 {
+		return nil
+	}
+	return append([]byte(nil), value...)
+}
+
+func (d *decoder) time() time.Time {
+	seconds := int64(d.uint64())
+	nanoseconds := d.uint32()
+	if d.err == nil && nanoseconds >= uint32(time.Second) {
+		d.err = errors.New("Time nanoseconds out of range")
+	}
+	return time.Unix(seconds, int64(nanoseconds)).UTC()
+}
+
+func (d *decoder) transaction() Transaction {
+	return Transaction{
+		Sender:    d.bytes(),
+		Receiver:  d.bytes(),
+		Amount:    uint(d.uint64()),
+		Fee:       uint(d.uint64()),
+		Nonce:     uint(d.uint64()),
+		Time:      d.time(),
+		Signature: d.bytes(),
+	}
+}
+
+func (d *decoder) header() BlockHeader {
+	return BlockHeader{
+		Number:       int(d.uint64()),
+		Time:         d.time(),
+		Difficulty:   d.uint64(),
+		PreviousHash: d.bytes(),
+		MerkleRoot:   d.bytes(),
+		Nonce:        int(d.uint64()),
+	}
+}
+
+// finish returns the first decoding error or an error if there are bytes left over
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("Encoding has trailing bytes")
+	}
+	return d.err
+}
+
+// MarshalBinary returns the binary encoding of the transaction including its signature
+func (t *Transaction) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	e.transaction(t)
+	return e.buffer, nil
+}
+
+// UnmarshalBinary decodes a transaction from its binary encoding
+func (t *Transaction) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	transaction := d.transaction()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*t = transaction
+	return nil
+}
+
+// MarshalBinary returns the binary encoding of the block header
+func (h *BlockHeader) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	e.header(h)
+	return e.buffer, nil
+}
+
+// UnmarshalBinary decodes a block header from its binary encoding
+func (h *BlockHeader) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	header := d.header()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*h = header
+	return nil
+}
+
+// MarshalBinary returns the binary encoding of the block and its transactions
+func (b *Block) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	header := b.Header()
+	e.header(&header)
+	e.uint32(uint32(len(b.Transactions)))
+	for i := range b.Transactions {
+		e.transaction(&b.Transactions[i])
+	}
+	return e.buffer, nil
+}
+
+// UnmarshalBinary decodes a block from its binary encoding. The hash of the block is not part of the
+// encoding, so it is computed from the decoded header.
+func (b *Block) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	header := d.header()
+	count := d.uint32()
+	// Every transaction takes at least this many bytes, which bounds the allocation below
+	const minTransactionSize = 4 + 4 + 8 + 8 + 8 + 8 + 4 + 4
+	if d.err == nil && uint64(count)*minTransactionSize > uint64(len(d.data)) {
+		return errors.New("Encoding is truncated")
+	}
+	transactions := make([]Transaction, 0, count)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		transactions = append(transactions, d.transaction())
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*b = *BlockFromHeader(header)
+	b.Transactions = transactions
+	return nil
+}
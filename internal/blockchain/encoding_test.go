@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// vectorTransaction returns the transaction used in the published test vectors. The keys are derived from
+// fixed seeds so that the signature is deterministic.
+func vectorTransaction() (*Transaction, ed25519.PrivateKey) {
+	sender := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	receiver := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	transaction := NewTransaction(sender.Public().(ed25519.PublicKey), receiver.Public().(ed25519.PublicKey), 25, 2, 1)
+	transaction.Time = time.Date(2021, time.June, 10, 14, 58, 22, 83545000, time.UTC)
+	return transaction, sender
+}
+
+func TestEncoding(t *testing.T) {
+	t.Run("Test transaction test vectors", func(t *testing.T) {
+		transaction, privateKey := vectorTransaction()
+		transaction.Sign(privateKey)
+
+		vectors := []struct {
+			name     string
+			actual   []byte
+			expected string
+		}{
+			{"signing payload", transaction.Bytes(), "01000000208a88e3dd7409f195fd52db2d3cba5d72ca6709bf1d94121bf3748801b40f6f5c000000208139770ea87d175f56a35466c34c7ecccb8d8a91b4ee37a25df60f5b8fc9b3940000000000000019000000000000000200000000000000010000000060c2288e04facba8"},
+			{"signature", transaction.Signature, "3ced86d36c60e3ad8c50f5ea6d34fbbfef4361fe2db9cf987af8ab09da872521726fcd29325c0849d52ba3892e2f5f13aa3bc6de2a16cddd1d314436f3b91403"},
+			{"hash", transaction.Hash(), "533d46d87b9dc03f877db62f6c09a3c3bc5c1aa2cebbb057c7f44533200fd952"},
+		}
+		for _, vector := range vectors {
+			if hex.EncodeToString(vector.actual) != vector.expected {
+				t.Errorf("Transaction %s %x differs from expected %s\n", vector.name, vector.actual, vector.expected)
+			}
+		}
+	})
+	t.Run("Test block header test vector", func(t *testing.T) {
+		header := BlockHeader{
+			Number:       1,
+			Time:         time.Date(2021, time.June, 10, 14, 58, 27, 730607000, time.UTC),
+			Difficulty:   genesisDifficulty,
+			PreviousHash: GenesisBlock().Hash,
+			MerkleRoot:   ComputeMerkleRoot(nil),
+			Nonce:        -2,
+		}
+		encoded, _ := header.MarshalBinary()
+		expected := "0100000000000000010000000060c228932b8c2d9800000000002000000000002000000500b89978b6b6d7f91026fb63c901458f1942c54529662556781208bcb1000000200000000000000000000000000000000000000000000000000000000000000000fffffffffffffffe"
+		if hex.EncodeToString(encoded) != expected {
+			t.Errorf("Block header encoding %x differs from expected %s\n", encoded, expected)
+		}
+	})
+	t.Run("Test block round trip", func(t *testing.T) {
+		transaction, privateKey := vectorTransaction()
+		transaction.Sign(privateKey)
+		miner := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+		genesisBlock := GenesisBlock()
+		block := NewBlock(genesisBlock.Number+1, genesisBlock.Hash, minDifficulty, []Transaction{CoinbaseTransactionTo(miner.Public().(ed25519.PublicKey), 2), *transaction}, 7)
+
+		encoded, err := block.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Block
+		if err := decoded.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("Failed to decode block: %v\n", err)
+		}
+		if !bytes.Equal(decoded.Hash, block.Hash) || !decoded.Time.Equal(block.Time) || decoded.Nonce != block.Nonce {
+			t.Error("Decoded block header differs from the encoded block")
+		}
+		if len(decoded.Transactions) != 2 || decoded.Transactions[0].Sender != nil || !decoded.Transactions[1].ValidSignature() {
+			t.Error("Decoded block transactions differ from the encoded block")
+		}
+		if !decoded.IsValid(genesisBlock) {
+			t.Error("Decoded block is not valid")
+		}
+	})
+	t.Run("Test rejecting malformed encodings", func(t *testing.T) {
+		transaction, _ := vectorTransaction()
+		encoded, _ := transaction.MarshalBinary()
+
+		malformed := map[string][]byte{
+			"empty":            {},
+			"unknown version":  append([]byte{EncodingVersion + 1}, encoded[1:]...),
+			"truncated":        encoded[:len(encoded)-1],
+			"trailing bytes":   append(append([]byte{}, encoded...), 0),
+			"oversized length": append([]byte{EncodingVersion}, 0xff, 0xff, 0xff, 0xff),
+		}
+		for name, data := range malformed {
+			var decoded Transaction
+			if err := decoded.UnmarshalBinary(data); err == nil {
+				t.Errorf("Decoding %s transaction did not fail\n", name)
+			}
+		}
+		var block Block
+		if err := block.UnmarshalBinary(encoded); err == nil {
+			t.Error("Decoding transaction as block did not fail")
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"sort"
+)
+
+// senderQueue holds the transactions of a single sender ordered by nonce
+type senderQueue []Transaction
+
+// feeQueue orders sender queues by the fee of their next transaction
+type feeQueue []senderQueue
+
+func (q feeQueue) Len() int { return len(q) }
+
+func (q feeQueue) Less(i, j int) bool {
+	if q[i][0].Fee == q[j][0].Fee {
+		return q[i][0].Time.Before(q[j][0].Time)
+	}
+	return q[i][0].Fee > q[j][0].Fee
+}
+
+func (q feeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *feeQueue) Push(x interface{}) { *q = append(*q, x.(senderQueue)) }
+
+func (q *feeQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// prioritizeByFee orders transactions so that the ones paying the highest fees come first. Blocks are
+// limited by transaction count rather than size, so the fee of a transaction is its fee rate. Transactions
+// from the same sender are always kept in nonce order, since they can only be applied in that order.
+func prioritizeByFee(transactions map[string]Transaction) []Transaction {
+	senders := make(map[string]senderQueue)
+	for _, transaction := range transactions {
+		sender := base64.StdEncoding.EncodeToString(transaction.Sender)
+		senders[sender] = append(senders[sender], transaction)
+	}
+
+	queue := make(feeQueue, 0, len(senders))
+	for _, transactions := range senders {
+		sort.Slice(transactions, func(i, j int) bool {
+			if transactions[i].Nonce == transactions[j].Nonce {
+				return transactions[i].Fee > transactions[j].Fee
+			}
+			return transactions[i].Nonce < transactions[j].Nonce
+		})
+		queue = append(queue, transactions)
+	}
+	heap.Init(&queue)
+
+	prioritized := make([]Transaction, 0, len(transactions))
+	for queue.Len() > 0 {
+		prioritized = append(prioritized, queue[0][0])
+		if len(queue[0]) > 1 {
+			queue[0] = queue[0][1:]
+			heap.Fix(&queue, 0)
+		} else {
+			heap.Pop(&queue)
+		}
+	}
+	return prioritized
+}
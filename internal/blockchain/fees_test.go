@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestFees(t *testing.T) {
+	t.Run("Test that transactions are prioritized by fee in nonce order", func(t *testing.T) {
+		first := keys.NewKeyPair()
+		second := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+
+		pool := make(map[string]Transaction)
+		for _, sender := range []struct {
+			keyPair *keys.KeyPair
+			fee     uint
+			nonce   uint
+		}{{first, 1, 1}, {first, 5, 2}, {second, 3, 1}} {
+			transaction := NewTransaction(sender.keyPair.PublicKey, receiver.PublicKey, 1, sender.fee, sender.nonce)
+			transaction.Sign(sender.keyPair.PrivateKey)
+			pool[string(transaction.Signature)] = *transaction
+		}
+
+		prioritized := prioritizeByFee(pool)
+		expectedFees := []uint{3, 1, 5}
+		for i, transaction := range prioritized {
+			if transaction.Fee != expectedFees[i] {
+				t.Fatalf("Expected fee %d at position %d but got %d\n", expectedFees[i], i, transaction.Fee)
+			}
+		}
+	})
+}
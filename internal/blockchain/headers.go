@@ -0,0 +1,81 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownParent is returned when a header chain does not start from a known block
+var ErrUnknownParent = errors.New("Header chain has an unknown parent")
+
+// HeaderChain is a chain of block headers which extends a known block. The headers are validated as they
+// are appended, so the blocks matching them can be downloaded knowing the proof-of-work is valid.
+type HeaderChain struct {
+	chain  *Blockchain
+	anchor *chainLink
+	links  []*chainLink
+}
+
+// NewHeaderChain returns an empty header chain extending the known block with the given hash
+func (b *Blockchain) NewHeaderChain(parentHash []byte) (*HeaderChain, error) {
+	b.RLock()
+	defer b.RUnlock()
+	anchor, exists := b.index[hashKey(parentHash)]
+	if !exists {
+		return nil, ErrUnknownParent
+	}
+	return &HeaderChain{chain: b, anchor: anchor}, nil
+}
+
+func (h *HeaderChain) tip() *chainLink {
+	if len(h.links) > 0 {
+		return h.links[len(h.links)-1]
+	}
+	return h.anchor
+}
+
+// Append validates the headers and appends them to the chain. Each header needs to link to the previous
+// one, meet its difficulty and have the difficulty the blockchain expects at its height.
+func (h *HeaderChain) Append(headers []BlockHeader) error {
+	for _, header := range headers {
+		parent := h.tip()
+		block := BlockFromHeader(header)
+		if !block.hasValidHeader(parent.block) {
+			return fmt.Errorf("Header %d is not valid", header.Number)
+		}
+		if block.Difficulty != h.chain.nextDifficulty(parent) {
+			return fmt.Errorf("Header %d has wrong difficulty", header.Number)
+		}
+		h.links = append(h.links, &chainLink{block: block, parent: parent, work: parent.work + block.Work()})
+	}
+	return nil
+}
+
+// Anchor returns the known block the header chain extends
+func (h *HeaderChain) Anchor() *Block {
+	return h.anchor.block
+}
+
+// Len returns the number of headers in the chain
+func (h *HeaderChain) Len() int {
+	return len(h.links)
+}
+
+// Header returns the header at the given height, which needs to be above the anchor
+func (h *HeaderChain) Header(number int) (BlockHeader, bool) {
+	index := number - h.anchor.block.Number - 1
+	if index < 0 || index >= len(h.links) {
+		return BlockHeader{}, false
+	}
+	return h.links[index].block.Header(), true
+}
+
+// Tip returns the last header in the chain
+func (h *HeaderChain) Tip() BlockHeader {
+	return h.tip().block.Header()
+}
+
+// Work returns the cumulative work of the blockchain ending at the last header
+func (h *HeaderChain) Work() uint64 {
+	return h.tip().work
+}
@@ -0,0 +1,358 @@
+package blockchain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultMempoolSize is the default maximum number of transactions in the pool
+	DefaultMempoolSize = 5000
+	// DefaultMempoolMaxAge is the default time after which transactions which have not made it into a
+	// block are dropped from the pool
+	DefaultMempoolMaxAge = 3 * time.Hour
+	// DefaultReplacementFeeBump is the default percentage by which a transaction needs to raise the fee to
+	// replace a transaction with the same sender and nonce
+	DefaultReplacementFeeBump = 10
+	// maxQueuedPerSender is the maximum number of queued transactions of a single sender, so that a sender
+	// can not fill the pool with transactions which can not be mined
+	maxQueuedPerSender = 64
+	// maxNonceGap is how far past the pending transactions of a sender the nonce of a queued transaction
+	// can be
+	maxNonceGap = 256
+	// maxRejections is the number of rejected transactions whose rejection reasons are remembered
+	maxRejections = 1000
+)
+
+// ErrMempoolFull is returned when the pool is full and the transaction pays too low a fee to replace any
+// of the transactions in it
+var ErrMempoolFull = errors.New("Transaction pool is full and transaction fee is too low")
+
+// mempool holds the transactions waiting to be included in a block. The transactions of each sender are
+// kept in nonce order. Transactions which continue the nonce sequence of their sender can be mined right
+// away and are pending, while transactions after a gap in the nonces are queued until the gap is filled.
+// A transaction with the same sender and nonce as a transaction in the pool replaces it if it raises the
+// fee by at least the fee bump percentage. The mempool also remembers why the most recently rejected
+// transactions were rejected. The mempool is guarded by the lock of the blockchain.
+type mempool struct {
+	senders map[string]*senderPool
+	// entries holds the transactions in the pool by their IDs
+	entries map[string]poolEntry
+	maxSize int
+	maxAge  time.Duration
+	feeBump uint
+	// rejections holds rejection reasons by transaction ID and rejected holds the IDs in the order they
+	// were rejected in, so that the oldest rejections can be forgotten
+	rejections map[string]string
+	rejected   []string
+}
+
+// poolEntry is a transaction in the pool along with the time it was added at
+type poolEntry struct {
+	transaction Transaction
+	added       time.Time
+}
+
+// senderPool holds the transactions of a single sender
+type senderPool struct {
+	// next is the nonce the account of the sender expects next
+	next    uint
+	pending []Transaction
+	queued  map[uint]Transaction
+}
+
+func newMempool(maxSize int, maxAge time.Duration) *mempool {
+	return &mempool{
+		senders:    make(map[string]*senderPool),
+		entries:    make(map[string]poolEntry),
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		feeBump:    DefaultReplacementFeeBump,
+		rejections: make(map[string]string),
+	}
+}
+
+// contains tells whether the transaction is in the pool
+func (m *mempool) contains(transaction Transaction) bool {
+	_, exists := m.entries[transaction.ID()]
+	return exists
+}
+
+// size returns the number of transactions in the pool
+func (m *mempool) size() int {
+	return len(m.entries)
+}
+
+// lookup returns the transaction with the given ID if it is in the pool and whether it is pending
+func (m *mempool) lookup(id string) (Transaction, bool, bool) {
+	entry, exists := m.entries[id]
+	if !exists {
+		return Transaction{}, false, false
+	}
+	pool := m.senders[base64.StdEncoding.EncodeToString(entry.transaction.Sender)]
+	return entry.transaction, entry.transaction.Nonce < pool.next+uint(len(pool.pending)), true
+}
+
+// reject remembers why the transaction with the given ID was rejected or dropped from the pool
+func (m *mempool) reject(id string, reason string) {
+	if _, exists := m.rejections[id]; !exists {
+		m.rejected = append(m.rejected, id)
+	}
+	m.rejections[id] = reason
+	if len(m.rejected) > maxRejections {
+		delete(m.rejections, m.rejected[0])
+		m.rejected = m.rejected[1:]
+	}
+}
+
+// rejection returns the reason the transaction with the given ID was rejected for
+func (m *mempool) rejection(id string) (string, bool) {
+	reason, exists := m.rejections[id]
+	return reason, exists
+}
+
+// add adds the transaction to the pool. The nonce of the account of the sender decides whether the
+// transaction is pending or queued. If the pool is full, the transaction paying the lowest fee is
+// evicted, which can be the transaction being added. A transaction replacing another one takes its place
+// without evicting anything.
+func (m *mempool) add(transaction Transaction, accountNonce uint, now time.Time) error {
+	if m.contains(transaction) {
+		return ErrKnownTransaction
+	}
+	m.update(transaction.Sender, accountNonce)
+	sender := base64.StdEncoding.EncodeToString(transaction.Sender)
+	pool, exists := m.senders[sender]
+	if !exists {
+		pool = &senderPool{next: accountNonce + 1, queued: make(map[uint]Transaction)}
+	}
+	replaced, err := pool.add(transaction, m.feeBump)
+	if err != nil {
+		return err
+	}
+	// The pool of a new sender is only kept once it holds a transaction, so that no empty pools are left
+	m.senders[sender] = pool
+	m.entries[transaction.ID()] = poolEntry{transaction, now}
+	if replaced != nil {
+		log.Printf("Replaced pooled transaction with nonce %d and fee %d by one with fee %d\n", transaction.Nonce, replaced.Fee, transaction.Fee)
+		delete(m.entries, replaced.ID())
+		m.reject(replaced.ID(), fmt.Sprintf("Transaction was replaced by transaction %s", transaction.ID()))
+		return nil
+	}
+	for m.size() > m.maxSize {
+		if evicted := m.evict(); evicted == transaction.ID() {
+			return ErrMempoolFull
+		}
+	}
+	return nil
+}
+
+// evict removes the transaction paying the lowest fee and returns its ID. Only the last transaction
+// of each sender is considered, since removing any other would leave a gap in the nonces of the sender.
+// Between equal fees the most recently added transaction is evicted.
+func (m *mempool) evict() string {
+	var lowest *Transaction
+	for _, pool := range m.senders {
+		last := pool.last()
+		if lowest == nil || last.Fee < lowest.Fee ||
+			(last.Fee == lowest.Fee && m.entries[last.ID()].added.After(m.entries[lowest.ID()].added)) {
+			lowest = last
+		}
+	}
+	id := lowest.ID()
+	m.remove(*lowest)
+	m.reject(id, ErrMempoolFull.Error())
+	return id
+}
+
+// remove removes the transaction from the pool. Pending transactions of the same sender with higher
+// nonces are queued again, since they can no longer be mined before the gap is filled.
+func (m *mempool) remove(transaction Transaction) {
+	if !m.contains(transaction) {
+		return
+	}
+	delete(m.entries, transaction.ID())
+	sender := base64.StdEncoding.EncodeToString(transaction.Sender)
+	pool := m.senders[sender]
+	pool.remove(transaction)
+	if pool.empty() {
+		delete(m.senders, sender)
+	}
+}
+
+// update tells the pool the nonce of the account of the sender, e.g. after a block changed it.
+// Transactions with nonces which have already been used are dropped and queued transactions are
+// promoted if they now continue the nonce sequence.
+func (m *mempool) update(sender []byte, accountNonce uint) {
+	key := base64.StdEncoding.EncodeToString(sender)
+	pool, exists := m.senders[key]
+	if !exists || pool.next == accountNonce+1 {
+		return
+	}
+	for _, transaction := range pool.reset(accountNonce + 1) {
+		delete(m.entries, transaction.ID())
+		m.reject(transaction.ID(), "Transaction nonce was used by another transaction")
+	}
+	if pool.empty() {
+		delete(m.senders, key)
+	}
+}
+
+// expire drops the transactions which were added to the pool longer than the maximum age ago
+func (m *mempool) expire(now time.Time) {
+	for _, pool := range m.senders {
+		for _, transaction := range pool.transactions() {
+			if now.Sub(m.entries[transaction.ID()].added) > m.maxAge {
+				m.remove(transaction)
+				m.reject(transaction.ID(), "Transaction expired from the pool")
+			}
+		}
+	}
+}
+
+// transactionsOf returns the transactions of the sender in the pool in nonce order
+func (m *mempool) transactionsOf(sender []byte) []Transaction {
+	pool, exists := m.senders[base64.StdEncoding.EncodeToString(sender)]
+	if !exists {
+		return nil
+	}
+	return pool.transactions()
+}
+
+// pending returns the transactions which can be mined right away keyed by ID
+func (m *mempool) pending() map[string]Transaction {
+	transactions := make(map[string]Transaction)
+	for _, pool := range m.senders {
+		for _, transaction := range pool.pending {
+			transactions[transaction.ID()] = transaction
+		}
+	}
+	return transactions
+}
+
+// add adds the transaction to the queued ones and promotes it if it continues the nonce sequence. The
+// number of queued transactions and how far ahead their nonces can be are limited. A transaction with the
+// same nonce is replaced if the fee is raised by at least the fee bump percentage,
+// in which case the replaced transaction is returned.
+func (p *senderPool) add(transaction Transaction, feeBump uint) (*Transaction, error) {
+	if transaction.Nonce < p.next {
+		return nil, errors.New("Transaction nonce has already been used")
+	}
+	// The offset is compared as an unsigned integer, since nonces can be too large to fit in an int
+	offset := transaction.Nonce - p.next
+	if offset >= uint(len(p.pending))+maxNonceGap {
+		return nil, fmt.Errorf("Transaction nonce is more than %d ahead of the pending transactions of the sender", maxNonceGap)
+	}
+	if offset < uint(len(p.pending)) {
+		replaced := p.pending[offset]
+		if err := checkReplacement(replaced, transaction, feeBump); err != nil {
+			return nil, err
+		}
+		p.pending[offset] = transaction
+		return &replaced, nil
+	}
+	if replaced, exists := p.queued[transaction.Nonce]; exists {
+		if err := checkReplacement(replaced, transaction, feeBump); err != nil {
+			return nil, err
+		}
+		p.queued[transaction.Nonce] = transaction
+		return &replaced, nil
+	}
+	if len(p.queued) >= maxQueuedPerSender {
+		return nil, fmt.Errorf("Sender already has %d queued transactions", maxQueuedPerSender)
+	}
+	p.queued[transaction.Nonce] = transaction
+	p.promote()
+	return nil, nil
+}
+
+// checkReplacement returns an error if the transaction does not raise the fee of the transaction it
+// replaces enough
+func checkReplacement(replaced Transaction, transaction Transaction, feeBump uint) error {
+	if required := replacementFee(replaced.Fee, feeBump); transaction.Fee < required {
+		return fmt.Errorf("Transaction with the same nonce is already in the pool, replacing it requires a fee of at least %d", required)
+	}
+	return nil
+}
+
+// replacementFee returns the minimum fee for replacing a transaction with the given fee. The fee always
+// needs to be raised by at least one coin, so that transactions can not be replaced for free.
+func replacementFee(fee uint, feeBump uint) uint {
+	bump := (fee*feeBump + 99) / 100
+	if bump == 0 {
+		bump = 1
+	}
+	return fee + bump
+}
+
+// promote moves queued transactions to the pending ones for as long as they continue the nonce sequence
+func (p *senderPool) promote() {
+	for {
+		transaction, exists := p.queued[p.next+uint(len(p.pending))]
+		if !exists {
+			return
+		}
+		delete(p.queued, transaction.Nonce)
+		p.pending = append(p.pending, transaction)
+	}
+}
+
+func (p *senderPool) remove(transaction Transaction) {
+	if queued, exists := p.queued[transaction.Nonce]; exists && queued.ID() == transaction.ID() {
+		delete(p.queued, transaction.Nonce)
+		return
+	}
+	for i, pending := range p.pending {
+		if pending.Nonce == transaction.Nonce {
+			for _, later := range p.pending[i+1:] {
+				p.queued[later.Nonce] = later
+			}
+			p.pending = p.pending[:i]
+			return
+		}
+	}
+}
+
+// reset changes the next expected nonce and returns the transactions which were dropped because their
+// nonces are lower
+func (p *senderPool) reset(next uint) []Transaction {
+	dropped := []Transaction{}
+	transactions := p.transactions()
+	p.next = next
+	p.pending = nil
+	p.queued = make(map[uint]Transaction)
+	for _, transaction := range transactions {
+		if transaction.Nonce < next {
+			dropped = append(dropped, transaction)
+			continue
+		}
+		p.queued[transaction.Nonce] = transaction
+	}
+	p.promote()
+	return dropped
+}
+
+// transactions returns all transactions of the sender in nonce order
+func (p *senderPool) transactions() []Transaction {
+	transactions := append([]Transaction{}, p.pending...)
+	for _, transaction := range p.queued {
+		transactions = append(transactions, transaction)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].Nonce < transactions[j].Nonce
+	})
+	return transactions
+}
+
+// last returns the transaction with the highest nonce
+func (p *senderPool) last() *Transaction {
+	transactions := p.transactions()
+	return &transactions[len(transactions)-1]
+}
+
+func (p *senderPool) empty() bool {
+	return len(p.pending) == 0 && len(p.queued) == 0
+}
@@ -0,0 +1,210 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestMempool(t *testing.T) {
+	receiver := keys.NewKeyPair()
+	signed := func(sender *keys.KeyPair, fee uint, nonce uint) Transaction {
+		transaction := NewTransaction(sender.PublicKey, receiver.PublicKey, 1, fee, nonce)
+		transaction.Sign(sender.PrivateKey)
+		return *transaction
+	}
+	now := time.Now()
+
+	t.Run("Test promoting queued transactions when nonce gap is filled", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(10, time.Hour)
+		for _, nonce := range []uint{3, 4} {
+			if err := pool.add(signed(sender, 1, nonce), 0, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(pool.pending()) != 0 {
+			t.Fatal("Transactions after nonce gap are pending")
+		}
+		pool.add(signed(sender, 1, 1), 0, now)
+		if len(pool.pending()) != 1 {
+			t.Fatal("Transaction continuing account nonce is not pending")
+		}
+		pool.add(signed(sender, 1, 2), 0, now)
+		if len(pool.pending()) != 4 {
+			t.Errorf("Queued transactions were not promoted: %d pending", len(pool.pending()))
+		}
+	})
+	t.Run("Test rejecting known transactions and used nonces", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(10, time.Hour)
+		transaction := signed(sender, 1, 2)
+		pool.add(transaction, 1, now)
+		if err := pool.add(transaction, 1, now); err != ErrKnownTransaction {
+			t.Errorf("Expected known transaction error but got %v", err)
+		}
+		if err := pool.add(signed(sender, 1, 2), 1, now); err == nil {
+			t.Error("Transaction with same nonce and fee as pooled transaction was accepted")
+		}
+		if err := pool.add(signed(sender, 1, 1), 1, now); err == nil {
+			t.Error("Transaction with used nonce was accepted")
+		}
+	})
+	t.Run("Test replacing transaction with same nonce by fee", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(10, time.Hour)
+		original := signed(sender, 20, 1)
+		pool.add(original, 0, now)
+		queued := signed(sender, 20, 3)
+		pool.add(queued, 0, now)
+
+		if err := pool.add(signed(sender, 21, 1), 0, now); err == nil {
+			t.Error("Replacement with too small fee bump was accepted")
+		}
+		replacement := signed(sender, 22, 1)
+		if err := pool.add(replacement, 0, now); err != nil {
+			t.Fatalf("Replacement was rejected: %v", err)
+		}
+		if pool.contains(original) || !pool.contains(replacement) || pool.size() != 2 {
+			t.Error("Pending transaction was not replaced")
+		}
+		if err := pool.add(signed(sender, 22, 3), 0, now); err != nil || pool.contains(queued) {
+			t.Errorf("Queued transaction was not replaced: %v", err)
+		}
+	})
+	t.Run("Test requiring fee bump of at least one coin", func(t *testing.T) {
+		for _, test := range []struct{ fee, bump, required uint }{{0, 10, 1}, {1, 10, 2}, {20, 10, 22}, {25, 10, 28}, {5, 0, 6}} {
+			if required := replacementFee(test.fee, test.bump); required != test.required {
+				t.Errorf("Expected replacement fee %d for fee %d but got %d", test.required, test.fee, required)
+			}
+		}
+	})
+	t.Run("Test evicting lowest fee transaction when pool is full", func(t *testing.T) {
+		first := keys.NewKeyPair()
+		second := keys.NewKeyPair()
+		pool := newMempool(2, time.Hour)
+		pool.add(signed(first, 5, 1), 0, now)
+		cheap := signed(second, 1, 1)
+		pool.add(cheap, 0, now)
+
+		if err := pool.add(signed(second, 1, 2), 0, now); err != ErrMempoolFull {
+			t.Errorf("Expected full pool error but got %v", err)
+		}
+		if err := pool.add(signed(first, 3, 2), 0, now); err != nil {
+			t.Fatal(err)
+		}
+		if pool.size() != 2 || pool.contains(cheap) {
+			t.Error("Lowest fee transaction was not evicted")
+		}
+	})
+	t.Run("Test evicting last transaction of sender to keep nonces contiguous", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		other := keys.NewKeyPair()
+		pool := newMempool(2, time.Hour)
+		low := signed(sender, 1, 1)
+		pool.add(low, 0, now)
+		pool.add(signed(sender, 4, 2), 0, now)
+		pool.add(signed(other, 2, 1), 0, now)
+		if !pool.contains(low) {
+			t.Error("Transaction with lower nonce than pooled transactions was evicted")
+		}
+		if len(pool.pending()) != 2 {
+			t.Errorf("Expected 2 pending transactions but got %d", len(pool.pending()))
+		}
+	})
+	t.Run("Test expiring old transactions", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(10, time.Hour)
+		old := signed(sender, 1, 1)
+		pool.add(old, 0, now.Add(-2*time.Hour))
+		pool.add(signed(sender, 1, 2), 0, now)
+		pool.expire(now)
+		if pool.contains(old) {
+			t.Fatal("Old transaction was not expired")
+		}
+		if pool.size() != 1 || len(pool.pending()) != 0 {
+			t.Error("Transaction after expired transaction is not queued")
+		}
+	})
+	t.Run("Test dropping transactions with nonces used by block", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(10, time.Hour)
+		for _, nonce := range []uint{1, 2, 4} {
+			pool.add(signed(sender, 1, nonce), 0, now)
+		}
+		pool.update(sender.PublicKey, 3)
+		if pool.size() != 1 || len(pool.pending()) != 1 {
+			t.Errorf("Expected 1 pending transaction but got %d of %d", len(pool.pending()), pool.size())
+		}
+	})
+	t.Run("Test that blockchain only mines pending transactions", func(t *testing.T) {
+		miner := keys.NewKeyPair()
+		chain := NewBlockchain(miner, WithMempoolLimits(10, time.Hour))
+		chain.MineBlock()
+		if err := chain.SubmitTransaction(signed(miner, 1, 2)); err != nil {
+			t.Fatal(err)
+		}
+		if block := chain.MineBlock(); len(block.Transactions) != 1 {
+			t.Fatal("Queued transaction was mined")
+		}
+		if err := chain.SubmitTransaction(signed(miner, 1, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if block := chain.MineBlock(); len(block.Transactions) != 3 {
+			t.Errorf("Expected 3 transactions but got %d", len(block.Transactions))
+		}
+		if chain.pool.size() != 0 {
+			t.Error("Mined transactions were not removed from pool")
+		}
+	})
+	t.Run("Test limiting queued transactions of sender", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(1000, time.Hour)
+		if err := pool.add(signed(sender, 1, maxNonceGap+1), 0, now); err == nil {
+			t.Error("Transaction too far ahead of account nonce was accepted")
+		}
+		for nonce := uint(2); nonce < maxQueuedPerSender+2; nonce++ {
+			if err := pool.add(signed(sender, 1, nonce), 0, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := pool.add(signed(sender, 1, maxQueuedPerSender+2), 0, now); err == nil {
+			t.Error("Queued transaction over limit was accepted")
+		}
+	})
+	t.Run("Test rejecting nonces which do not fit in an int", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		pool := newMempool(1, time.Hour)
+		if err := pool.add(signed(sender, 1, 1<<63+1), 0, now); err == nil {
+			t.Error("Transaction with huge nonce was accepted")
+		}
+		if len(pool.senders) != 0 {
+			t.Fatal("Sender of rejected transaction was kept in the pool")
+		}
+		pool.add(signed(keys.NewKeyPair(), 1, 1), 0, now)
+		if err := pool.add(signed(keys.NewKeyPair(), 2, 1), 0, now); err != nil || pool.size() != 1 {
+			t.Errorf("Full pool did not evict transaction: %v", err)
+		}
+	})
+	t.Run("Test checking balance against pooled transactions of sender", func(t *testing.T) {
+		miner := keys.NewKeyPair()
+		chain := NewBlockchain(miner, WithMempoolLimits(10, time.Hour))
+		chain.MineBlock()
+		half := NewTransaction(miner.PublicKey, receiver.PublicKey, CoinbaseTransactionAmount/2, 1, 1)
+		half.Sign(miner.PrivateKey)
+		if err := chain.SubmitTransaction(*half); err != nil {
+			t.Fatal(err)
+		}
+		rest := NewTransaction(miner.PublicKey, receiver.PublicKey, CoinbaseTransactionAmount/2, 1, 2)
+		rest.Sign(miner.PrivateKey)
+		if err := chain.SubmitTransaction(*rest); err == nil {
+			t.Error("Transaction exceeding balance left by pooled transactions was accepted")
+		}
+		replacement := NewTransaction(miner.PublicKey, receiver.PublicKey, CoinbaseTransactionAmount/2, 2, 1)
+		replacement.Sign(miner.PrivateKey)
+		if err := chain.SubmitTransaction(*replacement); err != nil {
+			t.Errorf("Replacement was checked against the transaction it replaces: %v", err)
+		}
+	})
+}
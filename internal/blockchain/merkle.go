@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so that an inner node can never be
+// presented as a leaf in a proof
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleStep is a single step of a Merkle inclusion proof, i.e. the hash of a sibling node
+type MerkleStep struct {
+	Hash []byte `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+func merkleLeaf(hash []byte) []byte {
+	digest := sha256.Sum256(append([]byte{merkleLeafPrefix}, hash...))
+	return digest[:]
+}
+
+func merkleNode(left []byte, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	digest := sha256.Sum256(data)
+	return digest[:]
+}
+
+// merkleLevel computes the parent level of the given level. A node without a sibling is carried
+// to the next level as is.
+func merkleLevel(level [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parents = append(parents, merkleNode(level[i], level[i+1]))
+	}
+	return parents
+}
+
+func merkleLeaves(hashes [][]byte) [][]byte {
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		leaves[i] = merkleLeaf(hash)
+	}
+	return leaves
+}
+
+// MerkleRoot computes the root of the Merkle tree over the given transaction hashes
+func MerkleRoot(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return make([]byte, sha256.Size)
+	}
+	level := merkleLeaves(hashes)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the proof that the transaction hash at the given index is included in the
+// Merkle tree over the given transaction hashes
+func MerkleProof(hashes [][]byte, index int) []MerkleStep {
+	proof := []MerkleStep{}
+	level := merkleLeaves(hashes)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, MerkleStep{Hash: level[sibling], Left: sibling < index})
+		}
+		level = merkleLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that the proof links the transaction hash to the Merkle root
+func VerifyMerkleProof(hash []byte, proof []MerkleStep, root []byte) bool {
+	node := merkleLeaf(hash)
+	for _, step := range proof {
+		if step.Left {
+			node = merkleNode(step.Hash, node)
+		} else {
+			node = merkleNode(node, step.Hash)
+		}
+	}
+	return bytes.Equal(node, root)
+}
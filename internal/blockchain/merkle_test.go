@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestMerkle(t *testing.T) {
+	hashes := [][]byte{}
+	for i := 0; i < 7; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		hashes = append(hashes, hash[:])
+	}
+
+	t.Run("Test verifying proofs for every transaction", func(t *testing.T) {
+		for size := 1; size <= len(hashes); size++ {
+			root := MerkleRoot(hashes[:size])
+			for index := 0; index < size; index++ {
+				proof := MerkleProof(hashes[:size], index)
+				if !VerifyMerkleProof(hashes[index], proof, root) {
+					t.Errorf("Proof for transaction %d in tree of %d transactions is not valid\n", index, size)
+				}
+			}
+		}
+	})
+	t.Run("Test rejecting proof for wrong transaction", func(t *testing.T) {
+		root := MerkleRoot(hashes)
+		proof := MerkleProof(hashes, 2)
+		if VerifyMerkleProof(hashes[3], proof, root) {
+			t.Error("Proof was valid for another transaction")
+		}
+	})
+	t.Run("Test that root depends on transaction order", func(t *testing.T) {
+		swapped := [][]byte{hashes[1], hashes[0]}
+		if bytes.Equal(MerkleRoot(hashes[:2]), MerkleRoot(swapped)) {
+			t.Error("Swapping transactions did not change Merkle root")
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTemplateRefreshInterval is how often the block being mined is rebuilt when the pool does not change
+const DefaultTemplateRefreshInterval = 30 * time.Second
+
+// cancelCheckInterval is the number of hashes a worker computes between checks for cancellation
+const cancelCheckInterval = 1 << 14
+
+// Miner searches for a proof-of-work by grinding the nonce of a serialized block header. Every worker owns
+// a disjoint range of nonces and rolls the timestamp of its header forward once it has exhausted its range,
+// so no two workers ever hash the same header.
+type Miner struct {
+	sync.Mutex
+	workers    int
+	nonceRange uint64
+	hashrate   float64
+}
+
+// NewMiner returns a miner with a worker per core
+func NewMiner() *Miner {
+	workers := runtime.NumCPU()
+	return &Miner{
+		workers:    workers,
+		nonceRange: math.MaxUint64 / uint64(workers),
+	}
+}
+
+// Hashrate returns the number of hashes per second computed during the most recent search
+func (m *Miner) Hashrate() float64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.hashrate
+}
+
+// Mine searches for a nonce with which the hash of the header meets its difficulty. The found header
+// is returned, or false if the context was cancelled before a nonce was found.
+func (m *Miner) Mine(ctx context.Context, header BlockHeader) (BlockHeader, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan BlockHeader, m.workers)
+	var hashes uint64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < m.workers; worker++ {
+		wg.Add(1)
+		go func(first uint64) {
+			defer wg.Done()
+			m.grind(ctx, header, first, found, &hashes)
+		}(uint64(worker) * m.nonceRange)
+	}
+
+	var result BlockHeader
+	var ok bool
+	select {
+	case result = <-found:
+		ok = true
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+
+	m.Lock()
+	m.hashrate = float64(atomic.LoadUint64(&hashes)) / time.Since(start).Seconds()
+	m.Unlock()
+	return result, ok
+}
+
+// grind hashes the header with every nonce in the range starting from first. The nonce is the last field
+// of the header encoding, so it is overwritten in place instead of encoding the header again.
+func (m *Miner) grind(ctx context.Context, header BlockHeader, first uint64, found chan<- BlockHeader, hashes *uint64) {
+	target := uint64(math.MaxUint64)
+	if header.Difficulty > 0 {
+		target /= header.Difficulty
+	}
+	encoded, _ := header.MarshalBinary()
+	nonce := encoded[len(encoded)-8:]
+	for {
+		for i := uint64(0); i < m.nonceRange; i++ {
+			if i%cancelCheckInterval == 0 && i > 0 {
+				atomic.AddUint64(hashes, cancelCheckInterval)
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+			}
+			binary.BigEndian.PutUint64(nonce, first+i)
+			hash := sha256.Sum256(encoded)
+			if binary.BigEndian.Uint64(hash[:8]) <= target {
+				atomic.AddUint64(hashes, i%cancelCheckInterval+1)
+				header.Nonce = int(first + i)
+				found <- header
+				return
+			}
+		}
+		atomic.AddUint64(hashes, (m.nonceRange-1)%cancelCheckInterval+1)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		// The range is exhausted, so continue with a later timestamp
+		header.Time = rollTime(header.Time)
+		encoded, _ = header.MarshalBinary()
+		nonce = encoded[len(encoded)-8:]
+	}
+}
+
+// rollTime returns the current time, or a nanosecond after the given time if the clock has not moved past it
+func rollTime(previous time.Time) time.Time {
+	now := time.Now().UTC()
+	if !now.After(previous) {
+		return previous.Add(time.Nanosecond)
+	}
+	return now
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestMiner(t *testing.T) {
+	template := func(difficulty uint64) BlockHeader {
+		genesisBlock := GenesisBlock()
+		return BlockHeader{
+			Number:       genesisBlock.Number + 1,
+			Time:         time.Now().UTC(),
+			Difficulty:   difficulty,
+			PreviousHash: genesisBlock.Hash,
+			MerkleRoot:   ComputeMerkleRoot(nil),
+		}
+	}
+
+	t.Run("Test mining header which meets difficulty", func(t *testing.T) {
+		miner := NewMiner()
+		header, ok := miner.Mine(context.Background(), template(1<<12))
+		if !ok {
+			t.Fatal("Miner did not find a nonce")
+		}
+		block := Block{Difficulty: header.Difficulty, Hash: header.Hash()}
+		if !block.MeetsDifficulty() {
+			t.Error("Mined header does not meet difficulty")
+		}
+		if miner.Hashrate() <= 0 {
+			t.Error("Miner did not report hashrate")
+		}
+	})
+	t.Run("Test rolling time when nonce range is exhausted", func(t *testing.T) {
+		miner := &Miner{workers: 2, nonceRange: 4}
+		original := template(1 << 10)
+		header, ok := miner.Mine(context.Background(), original)
+		if !ok {
+			t.Fatal("Miner did not find a nonce")
+		}
+		if header.Nonce < 0 || header.Nonce >= 8 {
+			t.Errorf("Nonce %d is outside of the worker ranges\n", header.Nonce)
+		}
+		if !header.Time.After(original.Time) {
+			t.Error("Miner did not roll time after exhausting nonce range")
+		}
+		block := Block{Difficulty: header.Difficulty, Hash: header.Hash()}
+		if !block.MeetsDifficulty() {
+			t.Error("Mined header does not meet difficulty")
+		}
+	})
+	t.Run("Test cancelling mining", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, ok := NewMiner().Mine(ctx, template(math.MaxUint64)); ok {
+			t.Error("Miner found a nonce for an impossible difficulty")
+		}
+	})
+}
+
+// mineBlockPerNonce mines the way blocks were mined before nonce grinding, by building and validating a
+// whole block for every nonce
+func mineBlockPerNonce(template *Block, previous *Block) *Block {
+	for nonce := 0; ; nonce++ {
+		block := Block{
+			Number:       template.Number,
+			Time:         template.Time,
+			Difficulty:   template.Difficulty,
+			MerkleRoot:   ComputeMerkleRoot(template.Transactions),
+			Transactions: template.Transactions,
+			PreviousHash: template.PreviousHash,
+			Nonce:        nonce,
+		}
+		block.Hash = block.ComputeHash()
+		if block.IsValid(previous) {
+			return &block
+		}
+	}
+}
+
+func BenchmarkMine(b *testing.B) {
+	genesis := GenesisBlock()
+	sender := keys.NewKeyPair()
+	transactions := []Transaction{CoinbaseTransactionTo(sender.PublicKey, 50)}
+	for nonce := uint(1); nonce <= 50; nonce++ {
+		transaction := NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, 1, nonce)
+		transaction.Sign(sender.PrivateKey)
+		transactions = append(transactions, *transaction)
+	}
+	// Every iteration mines a header with a different time, so that both loops search the same headers
+	template := func(i int) *Block {
+		block := NewBlock(genesis.Number+1, genesis.Hash, 1<<12, transactions, 0)
+		block.Time = genesis.Time.Add(time.Duration(i) * time.Second)
+		return block
+	}
+
+	// Both loops run on a single core and report the hashes they computed per second
+	b.Run("Block per nonce", func(b *testing.B) {
+		hashes := 0
+		start := time.Now()
+		for i := 0; i < b.N; i++ {
+			hashes += mineBlockPerNonce(template(i), genesis).Nonce + 1
+		}
+		b.ReportMetric(float64(hashes)/time.Since(start).Seconds(), "hashes/s")
+	})
+	b.Run("Nonce grinding", func(b *testing.B) {
+		miner := &Miner{workers: 1, nonceRange: math.MaxUint64}
+		hashes := 0
+		start := time.Now()
+		for i := 0; i < b.N; i++ {
+			header, _ := miner.Mine(context.Background(), template(i).Header())
+			hashes += header.Nonce + 1
+		}
+		b.ReportMetric(float64(hashes)/time.Since(start).Seconds(), "hashes/s")
+	})
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import "crypto/ed25519"
+
+// Transaction states reported by TransactionStatus
+const (
+	StatusUnknown  = "unknown"
+	StatusPending  = "pending"
+	StatusQueued   = "queued"
+	StatusIncluded = "included"
+	StatusRejected = "rejected"
+)
+
+// TransactionStatus tells how far a transaction has made it into the blockchain. Pending transactions
+// can be mined right away, while queued transactions wait for transactions with lower nonces from the
+// same sender. Included transactions are in a block on the main chain.
+type TransactionStatus struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	Block         *int   `json:"block,omitempty"`
+	BlockHash     []byte `json:"blockHash,omitempty"`
+	Confirmations int    `json:"confirmations,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// TransactionStatus returns the status of the transaction with the given ID. The reason is included if
+// the transaction was rejected or dropped from the pool.
+func (b *Blockchain) TransactionStatus(id string) TransactionStatus {
+	b.RLock()
+	defer b.RUnlock()
+	status := TransactionStatus{ID: id, Status: StatusUnknown}
+	if _, pending, exists := b.pool.lookup(id); exists {
+		status.Status = StatusQueued
+		if pending {
+			status.Status = StatusPending
+		}
+		return status
+	}
+	if location, included := b.included[id]; included {
+		status.Status = StatusIncluded
+		status.Block = &location.block
+		status.BlockHash = b.blocks[location.block].Hash
+		status.Confirmations = len(b.blocks) - location.block
+		return status
+	}
+	if reason, rejected := b.pool.rejection(id); rejected {
+		status.Status = StatusRejected
+		status.Reason = reason
+	}
+	return status
+}
+
+// PooledTransaction is a transaction in the pool along with the fee a transaction with the same sender and
+// nonce needs to pay to replace it
+type PooledTransaction struct {
+	Transaction    Transaction `json:"transaction"`
+	Status         string      `json:"status"`
+	ReplacementFee uint        `json:"replacementFee"`
+}
+
+// SenderTransactions are the transactions of a sender in the pool. NextNonce is the nonce which continues
+// the nonces of the account of the sender and its transactions in the pool.
+type SenderTransactions struct {
+	Address      ed25519.PublicKey   `json:"address"`
+	NextNonce    uint                `json:"nextNonce"`
+	Transactions []PooledTransaction `json:"transactions"`
+}
+
+// PooledTransactions returns the transactions of the sender in the pool in nonce order
+func (b *Blockchain) PooledTransactions(sender ed25519.PublicKey) SenderTransactions {
+	b.RLock()
+	defer b.RUnlock()
+	pooled := SenderTransactions{Address: sender, NextNonce: 1, Transactions: []PooledTransaction{}}
+	if account, err := b.accounts.Read(sender); err == nil {
+		pooled.NextNonce = account.Nonce + 1
+	}
+	for _, transaction := range b.pool.transactionsOf(sender) {
+		status := StatusQueued
+		if transaction.Nonce == pooled.NextNonce {
+			status = StatusPending
+			pooled.NextNonce++
+		}
+		pooled.Transactions = append(pooled.Transactions, PooledTransaction{
+			Transaction:    transaction,
+			Status:         status,
+			ReplacementFee: replacementFee(transaction.Fee, b.pool.feeBump),
+		})
+	}
+	return pooled
+}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestTransactionStatus(t *testing.T) {
+	sender := keys.NewKeyPair()
+	signed := func(fee uint, nonce uint) Transaction {
+		transaction := NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, fee, nonce)
+		transaction.Sign(sender.PrivateKey)
+		return *transaction
+	}
+	chain := NewBlockchain(sender)
+	chain.MineBlock()
+
+	t.Run("Test status of unknown transaction", func(t *testing.T) {
+		unknown := signed(1, 1)
+		if status := chain.TransactionStatus(unknown.ID()); status.Status != StatusUnknown {
+			t.Errorf("Expected unknown status but got %+v", status)
+		}
+	})
+	t.Run("Test status of pooled and mined transactions", func(t *testing.T) {
+		pending := signed(1, 1)
+		queued := signed(1, 3)
+		for _, transaction := range []Transaction{pending, queued} {
+			if err := chain.SubmitTransaction(transaction); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if status := chain.TransactionStatus(pending.ID()); status.Status != StatusPending {
+			t.Errorf("Expected pending status but got %+v", status)
+		}
+		if status := chain.TransactionStatus(queued.ID()); status.Status != StatusQueued {
+			t.Errorf("Expected queued status but got %+v", status)
+		}
+
+		block := chain.MineBlock()
+		chain.MineBlock()
+		status := chain.TransactionStatus(pending.ID())
+		if status.Status != StatusIncluded || *status.Block != block.Number || status.Confirmations != 2 {
+			t.Errorf("Expected inclusion in block %d with 2 confirmations but got %+v", block.Number, status)
+		}
+	})
+	t.Run("Test reason for rejected and replaced transactions", func(t *testing.T) {
+		used := signed(1, 1)
+		if chain.SubmitTransaction(used) == nil {
+			t.Fatal("Transaction with used nonce was accepted")
+		}
+		if status := chain.TransactionStatus(used.ID()); status.Status != StatusRejected || status.Reason == "" {
+			t.Errorf("Expected rejection with reason but got %+v", status)
+		}
+
+		original := signed(1, 2)
+		replacement := signed(2, 2)
+		chain.SubmitTransaction(original)
+		if err := chain.SubmitTransaction(replacement); err != nil {
+			t.Fatal(err)
+		}
+		if status := chain.TransactionStatus(original.ID()); status.Status != StatusRejected || status.Reason == "" {
+			t.Errorf("Expected replaced transaction to be rejected but got %+v", status)
+		}
+	})
+	t.Run("Test status of transactions in orphaned blocks", func(t *testing.T) {
+		firstChain := NewBlockchain(sender, withTestGenesis())
+		secondChain := NewBlockchain(keys.NewKeyPair(), withTestGenesis())
+		orphaned := firstChain.MineBlock().Transactions[0]
+		for i := 0; i < 2; i++ {
+			secondChain.MineBlock()
+		}
+		if status := firstChain.TransactionStatus(orphaned.ID()); status.Status != StatusIncluded {
+			t.Fatalf("Expected included status but got %+v", status)
+		}
+		for _, block := range secondChain.blocks[1:] {
+			if err := firstChain.addBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if status := firstChain.TransactionStatus(orphaned.ID()); status.Status != StatusUnknown {
+			t.Errorf("Expected transaction in orphaned block to be unknown but got %+v", status)
+		}
+		if _, _, found := firstChain.FindTransaction(orphaned.ID()); found {
+			t.Error("Transaction in orphaned block was found")
+		}
+		included := secondChain.blocks[2].Transactions[0]
+		if status := firstChain.TransactionStatus(included.ID()); status.Status != StatusIncluded || *status.Block != 2 {
+			t.Errorf("Expected inclusion in block 2 but got %+v", status)
+		}
+		if block, index, found := firstChain.FindTransaction(included.ID()); !found || block.Number != 2 || index != 0 {
+			t.Errorf("Transaction was not found in block 2: %v", block)
+		}
+	})
+}
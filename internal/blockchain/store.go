@@ -0,0 +1,316 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// BlockStore persists every block the blockchain accepts, including blocks on side branches
+type BlockStore interface {
+	// Append stores a block. A block is always appended after its parent.
+	Append(block *Block) error
+	// Blocks returns all stored blocks in the order they were appended
+	Blocks() ([]*Block, error)
+	// Block returns the stored block with the given hash
+	Block(hash []byte) (*Block, error)
+	// BlocksAt returns all stored blocks with the given number
+	BlocksAt(number int) ([]*Block, error)
+	// Close closes the store
+	Close() error
+}
+
+const (
+	maxSegmentSize    = 64 << 20
+	recordHeaderSize  = 8
+	segmentFilePrefix = "segment-"
+	segmentFileSuffix = ".dat"
+)
+
+// blockLocation is the position of a block record within the segment files
+type blockLocation struct {
+	segment int
+	offset  int64
+}
+
+// FileStore is a BlockStore which appends binary encoded blocks to segment files in a directory. Each record
+// in a segment is prefixed with its length and checksum, so a record left incomplete by a crash is detected and discarded
+// when the store is reopened. The index by hash and number is rebuilt by scanning the segments on open.
+type FileStore struct {
+	sync.Mutex
+	dir      string
+	segments []int
+	file     *os.File
+	size     int64
+	byHash   map[string]blockLocation
+	byNumber map[int][]blockLocation
+}
+
+// OpenFileStore opens or creates a block store in the given directory
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	store := &FileStore{
+		dir:      dir,
+		byHash:   make(map[string]blockLocation),
+		byNumber: make(map[int][]blockLocation),
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, segmentFilePrefix+"*"+segmentFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range segments {
+		var segment int
+		if _, err := fmt.Sscanf(filepath.Base(path), segmentFilePrefix+"%06d"+segmentFileSuffix, &segment); err != nil {
+			return nil, fmt.Errorf("Unexpected segment file %s", path)
+		}
+		store.segments = append(store.segments, segment)
+	}
+	sort.Ints(store.segments)
+	for i, segment := range store.segments {
+		if err := store.scanSegment(segment, i == len(store.segments)-1); err != nil {
+			return nil, err
+		}
+	}
+	if len(store.segments) == 0 {
+		store.segments = append(store.segments, 0)
+	}
+	if err := store.openSegment(store.segments[len(store.segments)-1]); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileStore) segmentPath(segment int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%06d%s", segmentFilePrefix, segment, segmentFileSuffix))
+}
+
+// scanSegment indexes the records in a segment. An incomplete or corrupted record at the end of the last
+// segment is the result of a crash during a write, so it is truncated away instead of causing an error.
+func (s *FileStore) scanSegment(segment int, last bool) error {
+	file, err := os.Open(s.segmentPath(segment))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		block, size, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("Segment %d is corrupted at offset %d: %v", segment, offset, err)
+			}
+			log.Printf("Discarding incomplete block record in segment %d at offset %d: %v\n", segment, offset, err)
+			return os.Truncate(s.segmentPath(segment), offset)
+		}
+		s.index(block, blockLocation{segment, offset})
+		offset += size
+	}
+}
+
+// openSegment opens a segment for appending, creating it if it does not exist yet. The directory is synced
+// after creating a segment, since otherwise a crash could lose the segment even though its records were
+// synced.
+func (s *FileStore) openSegment(segment int) error {
+	_, err := os.Stat(s.segmentPath(segment))
+	created := os.IsNotExist(err)
+	file, err := os.OpenFile(s.segmentPath(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if created {
+		if err := syncDir(s.dir); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// syncDir syncs the entries of the directory to disk
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func (s *FileStore) index(block *Block, location blockLocation) {
+	s.byHash[base64.StdEncoding.EncodeToString(block.Hash)] = location
+	s.byNumber[block.Number] = append(s.byNumber[block.Number], location)
+}
+
+// readRecord reads a single record and returns the block along with the size of the record
+func readRecord(reader io.Reader) (*Block, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("Truncated record header")
+		}
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxSegmentSize {
+		return nil, 0, errors.New("Record length exceeds segment size")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errors.New("Truncated record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("Record checksum mismatch")
+	}
+	var block Block
+	if err := block.UnmarshalBinary(payload); err != nil {
+		return nil, 0, err
+	}
+	return &block, int64(recordHeaderSize + length), nil
+}
+
+// Append writes the block to the end of the current segment and syncs it to disk
+func (s *FileStore) Append(block *Block) error {
+	s.Lock()
+	defer s.Unlock()
+
+	payload, err := block.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	if s.size > 0 && s.size+int64(len(record)) > maxSegmentSize {
+		if err := s.rollSegment(); err != nil {
+			return err
+		}
+	}
+	// Remove any partially written or unsynced record so the next append starts from a clean offset
+	if _, err := s.file.Write(record); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.index(block, blockLocation{s.segments[len(s.segments)-1], s.size})
+	s.size += int64(len(record))
+	return nil
+}
+
+// rollSegment continues appending to a new segment. The current segment is kept open if the new one can
+// not be opened.
+func (s *FileStore) rollSegment() error {
+	current := s.file
+	next := s.segments[len(s.segments)-1] + 1
+	if err := s.openSegment(next); err != nil {
+		return err
+	}
+	s.segments = append(s.segments, next)
+	if err := current.Close(); err != nil {
+		log.Printf("Failed to close segment %d: %v\n", next-1, err)
+	}
+	return nil
+}
+
+func (s *FileStore) read(location blockLocation) (*Block, error) {
+	file, err := os.Open(s.segmentPath(location.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(location.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	block, _, err := readRecord(file)
+	return block, err
+}
+
+// Blocks returns all stored blocks in the order they were appended
+func (s *FileStore) Blocks() ([]*Block, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	blocks := make([]*Block, 0, len(s.byHash))
+	for _, segment := range s.segments {
+		file, err := os.Open(s.segmentPath(segment))
+		if err != nil {
+			return nil, err
+		}
+		reader := bufio.NewReader(file)
+		for {
+			block, _, err := readRecord(reader)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			blocks = append(blocks, block)
+		}
+		file.Close()
+	}
+	return blocks, nil
+}
+
+// Block returns the stored block with the given hash
+func (s *FileStore) Block(hash []byte) (*Block, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	location, exists := s.byHash[base64.StdEncoding.EncodeToString(hash)]
+	if !exists {
+		return nil, fmt.Errorf("Block %x is not stored", hash)
+	}
+	return s.read(location)
+}
+
+// BlocksAt returns all stored blocks with the given number
+func (s *FileStore) BlocksAt(number int) ([]*Block, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	blocks := []*Block{}
+	for _, location := range s.byNumber[number] {
+		block, err := s.read(location)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Close closes the current segment file
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestStore(t *testing.T) {
+	miner := keys.NewKeyPair()
+	genesis := GenesisBlock()
+	first := NewBlock(1, genesis.Hash, genesis.Difficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0)}, 0)
+	second := NewBlock(2, first.Hash, genesis.Difficulty, []Transaction{CoinbaseTransactionTo(miner.PublicKey, 0)}, 0)
+
+	t.Run("Test reading stored blocks after reopening store", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := OpenFileStore(dir)
+		if err != nil {
+			t.Fatal("Failed to open store:", err)
+		}
+		for _, block := range []*Block{first, second} {
+			if err := store.Append(block); err != nil {
+				t.Fatal("Failed to append block:", err)
+			}
+		}
+		store.Close()
+
+		store, err = OpenFileStore(dir)
+		if err != nil {
+			t.Fatal("Failed to reopen store:", err)
+		}
+		defer store.Close()
+		blocks, err := store.Blocks()
+		if err != nil {
+			t.Fatal("Failed to read blocks:", err)
+		}
+		if len(blocks) != 2 || !bytes.Equal(blocks[0].Hash, first.Hash) || !bytes.Equal(blocks[1].Hash, second.Hash) {
+			t.Error("Store returned wrong blocks")
+		}
+		block, err := store.Block(second.Hash)
+		if err != nil || !bytes.Equal(block.Hash, second.Hash) {
+			t.Error("Failed to read block by hash:", err)
+		}
+		blocks, err = store.BlocksAt(1)
+		if err != nil || len(blocks) != 1 || !bytes.Equal(blocks[0].Hash, first.Hash) {
+			t.Error("Failed to read block by number:", err)
+		}
+	})
+	t.Run("Test discarding incomplete record after crash", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir)
+		store.Append(first)
+		store.Append(second)
+		store.Close()
+
+		// Simulate a crash in the middle of writing the second block
+		info, _ := os.Stat(store.segmentPath(0))
+		if err := os.Truncate(store.segmentPath(0), info.Size()-10); err != nil {
+			t.Fatal("Failed to truncate segment:", err)
+		}
+
+		store, err := OpenFileStore(dir)
+		if err != nil {
+			t.Fatal("Failed to reopen store:", err)
+		}
+		blocks, _ := store.Blocks()
+		if len(blocks) != 1 || !bytes.Equal(blocks[0].Hash, first.Hash) {
+			t.Fatal("Store did not discard incomplete record")
+		}
+		if err := store.Append(second); err != nil {
+			t.Fatal("Failed to append block after recovery:", err)
+		}
+		store.Close()
+
+		store, _ = OpenFileStore(dir)
+		defer store.Close()
+		if blocks, _ := store.Blocks(); len(blocks) != 2 {
+			t.Errorf("Expected %d blocks after recovery but found %d\n", 2, len(blocks))
+		}
+	})
+	t.Run("Test keeping current segment when new segment can not be created", func(t *testing.T) {
+		dir := t.TempDir()
+		store, _ := OpenFileStore(dir)
+		store.Append(first)
+
+		// A directory in place of the next segment makes creating it fail, and the store is made to believe
+		// the current segment is full
+		if err := os.Mkdir(store.segmentPath(1), 0755); err != nil {
+			t.Fatal(err)
+		}
+		store.size = maxSegmentSize
+		if err := store.Append(second); err == nil {
+			t.Fatal("Block was appended without a segment to append to")
+		}
+		if len(store.segments) != 1 {
+			t.Fatalf("Segment which could not be created was added: %v", store.segments)
+		}
+		os.Remove(store.segmentPath(1))
+		if err := store.Append(second); err != nil {
+			t.Fatal("Failed to append block after rolling segment:", err)
+		}
+		store.Close()
+
+		store, _ = OpenFileStore(dir)
+		defer store.Close()
+		if blocks, _ := store.Blocks(); len(blocks) != 2 || !bytes.Equal(blocks[1].Hash, second.Hash) {
+			t.Errorf("Expected %d blocks after rolling segment but found %d\n", 2, len(blocks))
+		}
+	})
+}
@@ -2,8 +2,10 @@ package blockchain
 
 import (
 	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -12,11 +14,15 @@ const (
 	CoinbaseTransactionAmount = 10
 )
 
+// ErrInvalidSignature is returned for transactions whose signature was not made by the sender
+var ErrInvalidSignature = errors.New("Transaction has invalid signature")
+
 // Transaction represents an individual transaction
 type Transaction struct {
 	Sender    []byte    `json:"sender"`
 	Receiver  []byte    `json:"receiver"`
 	Amount    uint      `json:"amount"`
+	Fee       uint      `json:"fee"`
 	Nonce     uint      `json:"nonce"`
 	Time      time.Time `json:"time"`
 	Signature []byte    `json:"signature"`
@@ -29,53 +35,61 @@ func (t Transaction) String() string {
 	if t.Sender == nil {
 		return fmt.Sprintf("Transaction: %d coins to miner %s", t.Amount, receiver)
 	}
-	return fmt.Sprintf("Transaction: %d coins from %s to %s", t.Amount, sender, receiver)
+	return fmt.Sprintf("Transaction: %d coins from %s to %s with fee %d", t.Amount, sender, receiver, t.Fee)
 }
 
 // NewTransaction returns a new unsigned transaction
-func NewTransaction(sender ed25519.PublicKey, receiver ed25519.PublicKey, amount uint, nonce uint) *Transaction {
+func NewTransaction(sender ed25519.PublicKey, receiver ed25519.PublicKey, amount uint, fee uint, nonce uint) *Transaction {
 	return &Transaction{
 		sender,
 		receiver,
 		amount,
+		fee,
 		nonce,
 		time.Now().UTC(),
 		nil,
 	}
 }
 
-// Bytes returns the transaction as bytes
-func (t *Transaction) Bytes() ([]byte, error) {
-	// Omit the signature since the signature is used to sign this
-	copy := Transaction{
-		Sender:   t.Sender,
-		Receiver: t.Receiver,
-		Amount:   t.Amount,
-		Nonce:    t.Nonce,
-		Time:     t.Time,
-	}
+// Bytes returns the binary encoding of the transaction without its signature, which is the payload
+// signed by the sender
+func (t *Transaction) Bytes() []byte {
+	e := newEncoder()
+	e.unsignedTransaction(t)
+	return e.buffer
+}
 
-	bytes, err := json.Marshal(copy)
-	if err != nil {
-		return nil, err
-	}
-	return bytes, nil
+// Hash returns the hash of the binary encoding of the whole transaction including its signature
+func (t *Transaction) Hash() []byte {
+	bytes, _ := t.MarshalBinary()
+	hash := sha256.Sum256(bytes)
+	return hash[:]
+}
+
+// ID returns the hex encoded hash of the transaction, which identifies the transaction in the API
+func (t *Transaction) ID() string {
+	return hex.EncodeToString(t.Hash())
 }
 
 // Sign signs the transaction using the given key and returns the signature
 func (t *Transaction) Sign(privateKey ed25519.PrivateKey) ([]byte, error) {
-	bytes, err := t.Bytes()
-	if err != nil {
-		return nil, err
-	}
-	signature := ed25519.Sign(privateKey, bytes)
+	signature := ed25519.Sign(privateKey, t.Bytes())
 	t.Signature = signature
 	return signature, nil
 }
 
 // IsCoinBase tells whether the transaction is a coinbase transaction
 func (t *Transaction) IsCoinbase() bool {
-	return t.Sender == nil && t.Receiver != nil && t.Amount == CoinbaseTransactionAmount
+	return t.Sender == nil && t.Receiver != nil && t.Amount >= CoinbaseTransactionAmount && t.Fee == 0
+}
+
+// Cost returns the total amount deducted from the sender, i.e. the amount plus the fee
+func (t *Transaction) Cost() (uint, error) {
+	cost := t.Amount + t.Fee
+	if cost < t.Amount {
+		return 0, errors.New("Transaction cost overflows")
+	}
+	return cost, nil
 }
 
 // ValidSignature indicates whether the transaction signature is valid
@@ -84,20 +98,18 @@ func (t *Transaction) ValidSignature() bool {
 	if t.Sender == nil {
 		return t.IsCoinbase()
 	}
-
-	bytes, err := t.Bytes()
-	if err != nil {
+	if len(t.Sender) != ed25519.PublicKeySize {
 		return false
 	}
-	return ed25519.Verify(t.Sender, bytes, t.Signature)
+	return ed25519.Verify(t.Sender, t.Bytes(), t.Signature)
 }
 
-// CoinbaseTransaction contructs a coinbase transaction
-func CoinbaseTransactionTo(receiver ed25519.PublicKey) Transaction {
+// CoinbaseTransaction contructs a coinbase transaction which collects the given transaction fees
+func CoinbaseTransactionTo(receiver ed25519.PublicKey, fees uint) Transaction {
 	return Transaction{
 		Sender:   nil,
 		Receiver: receiver,
-		Amount:   CoinbaseTransactionAmount,
+		Amount:   CoinbaseTransactionAmount + fees,
 		Time:     time.Now().UTC(),
 	}
 }
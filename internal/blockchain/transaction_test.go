@@ -52,4 +52,16 @@ func TestTransaction(t *testing.T) {
 			t.Error("Coinbase transactions signatures are always considered valid")
 		}
 	})
+	t.Run("Test that fee is covered by signature", func(t *testing.T) {
+		senderKeyPair := keys.NewKeyPair()
+		receiverKeyPair := keys.NewKeyPair()
+
+		transaction := NewTransaction(senderKeyPair.PublicKey, receiverKeyPair.PublicKey, 10, 1, 1)
+		transaction.Sign(senderKeyPair.PrivateKey)
+		transaction.Fee = 0
+
+		if transaction.ValidSignature() {
+			t.Error("Signature should not be valid after changing fee")
+		}
+	})
 }
@@ -1,6 +1,13 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // SeedHost returns the seed host for the node, i.e. the node to synchronize blockchain state from
 func SeedHost() (string, bool) {
@@ -22,3 +29,130 @@ func AdvertisedHost() string {
 	}
 	return BindHost()
 }
+
+// duration returns the positive duration in the environment variable, or the default if it is not set or invalid
+func duration(variable string, defaultDuration time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(variable); ok {
+		parsed, err := time.ParseDuration(value)
+		if err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("Ignoring invalid duration %q in %s\n", value, variable)
+	}
+	return defaultDuration
+}
+
+// integer returns the positive integer in the environment variable, or the default if it is not set or invalid
+func integer(variable string, defaultInteger int) int {
+	if value, ok := os.LookupEnv(variable); ok {
+		parsed, err := strconv.Atoi(value)
+		if err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("Ignoring invalid integer %q in %s\n", value, variable)
+	}
+	return defaultInteger
+}
+
+// ChainID returns the identifier of the network the node belongs to. Nodes only connect to peers on the
+// same network.
+func ChainID() string {
+	if chainID, ok := os.LookupEnv("NODE_CHAIN_ID"); ok {
+		return chainID
+	}
+	return "cryptocurrency"
+}
+
+// boolean returns whether the environment variable is set to true, or the default if it is not set or invalid
+func boolean(variable string, defaultBoolean bool) bool {
+	if value, ok := os.LookupEnv(variable); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err == nil {
+			return parsed
+		}
+		log.Printf("Ignoring invalid boolean %q in %s\n", value, variable)
+	}
+	return defaultBoolean
+}
+
+// Insecure tells whether the node communicates with other nodes over plain HTTP instead of TLS. This is
+// meant for local development only.
+func Insecure() bool {
+	return boolean("NODE_INSECURE", false)
+}
+
+// MutualTLS tells whether the node requires peers to present a certificate for their identity
+func MutualTLS() bool {
+	return boolean("NODE_MUTUAL_TLS", false)
+}
+
+// TargetBlockInterval returns the average time between blocks which the mining difficulty is adjusted towards
+func TargetBlockInterval() time.Duration {
+	return duration("NODE_TARGET_BLOCK_INTERVAL", 15*time.Second)
+}
+
+// TemplateRefreshInterval returns how often the miner rebuilds the block it is mining when no new
+// transactions arrive
+func TemplateRefreshInterval() time.Duration {
+	return duration("NODE_TEMPLATE_REFRESH_INTERVAL", 30*time.Second)
+}
+
+// TipPollInterval returns how often the node asks its peers for their chain tips to catch up with them
+func TipPollInterval() time.Duration {
+	return duration("NODE_TIP_POLL_INTERVAL", 30*time.Second)
+}
+
+// PeerExchangeInterval returns how often the node asks its peers for more peers
+func PeerExchangeInterval() time.Duration {
+	return duration("NODE_PEER_EXCHANGE_INTERVAL", time.Minute)
+}
+
+// MaxInboundPeers returns the maximum number of peers which can connect to the node
+func MaxInboundPeers() int {
+	return integer("NODE_MAX_INBOUND_PEERS", 16)
+}
+
+// MaxOutboundPeers returns the maximum number of peers the node connects to
+func MaxOutboundPeers() int {
+	return integer("NODE_MAX_OUTBOUND_PEERS", 8)
+}
+
+// BanDuration returns how long hosts which send too many invalid messages are banned for
+func BanDuration() time.Duration {
+	return duration("NODE_BAN_DURATION", 24*time.Hour)
+}
+
+// MempoolSize returns the maximum number of transactions the node keeps in its pool
+func MempoolSize() int {
+	return integer("NODE_MEMPOOL_SIZE", 5000)
+}
+
+// MempoolMaxAge returns how long transactions are kept in the pool if they do not make it into a block
+func MempoolMaxAge() time.Duration {
+	return duration("NODE_MEMPOOL_MAX_AGE", 3*time.Hour)
+}
+
+// ReplacementFeeBump returns the percentage by which a transaction needs to raise the fee of a pooled
+// transaction with the same sender and nonce to replace it
+func ReplacementFeeBump() uint {
+	return uint(integer("NODE_REPLACEMENT_FEE_BUMP", 10))
+}
+
+// Archival tells whether the node keeps past account states so that they can be queried at any height
+func Archival() bool {
+	return boolean("NODE_ARCHIVAL", false)
+}
+
+// CheckpointInterval returns the number of blocks between the account state checkpoints of an archival node
+func CheckpointInterval() int {
+	return integer("NODE_CHECKPOINT_INTERVAL", 1000)
+}
+
+// DataDir returns the directory the node persists its blockchain to. By default each bind address gets its
+// own directory, so that multiple nodes can run from the same working directory.
+func DataDir() string {
+	if dir, ok := os.LookupEnv("NODE_DATA_DIR"); ok {
+		return dir
+	}
+	return filepath.Join("data", strings.ReplaceAll(BindHost(), ":", "_"))
+}
@@ -45,3 +45,17 @@ func LoadKeyPair(privateKeyPath string) (*KeyPair, error) {
 		base64.StdEncoding.EncodeToString(publicKey),
 	}, nil
 }
+
+// LoadOrCreateKeyPair loads key pair from private key file, or generates a new key pair and writes its
+// private key to the file if the file does not exist yet
+func LoadOrCreateKeyPair(privateKeyPath string) (*KeyPair, error) {
+	keyPair, err := LoadKeyPair(privateKeyPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		return keyPair, err
+	}
+	keyPair = NewKeyPair()
+	if err := os.WriteFile(privateKeyPath, keyPair.PrivateKey.Seed(), 0600); err != nil {
+		return nil, err
+	}
+	return keyPair, nil
+}
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxAddressBookSize  = 1024
+	initialRetryBackoff = 30 * time.Second
+	maxRetryBackoff     = time.Hour
+	// maxAddressFailures is the number of failed connection attempts after which an address is forgotten
+	maxAddressFailures = 10
+)
+
+// addressEntry is an address in the address book
+type addressEntry struct {
+	Address  string    `json:"address"`
+	LastSeen time.Time `json:"lastSeen"`
+	Failures int       `json:"failures"`
+	RetryAt  time.Time `json:"retryAt"`
+}
+
+// AddressBook keeps the addresses of peer nodes this node has connected to or heard of, along with when
+// they can be tried again. It is persisted so that a node can rejoin the network without a seed host.
+type AddressBook struct {
+	sync.Mutex
+	entries map[string]*addressEntry
+}
+
+// NewAddressBook returns an empty address book
+func NewAddressBook() *AddressBook {
+	return &AddressBook{entries: make(map[string]*addressEntry)}
+}
+
+// retryBackoff returns how long to wait before retrying an address which has failed the given number of times
+func retryBackoff(failures int) time.Duration {
+	backoff := initialRetryBackoff
+	for i := 1; i < failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// Add adds an address which has not been connected to yet. False is returned if the book is full.
+func (b *AddressBook) Add(address string) bool {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.entries[address]; ok {
+		return true
+	}
+	if len(b.entries) >= maxAddressBookSize {
+		return false
+	}
+	b.entries[address] = &addressEntry{Address: address}
+	return true
+}
+
+// Seen records that the peer at the address responded
+func (b *AddressBook) Seen(address string) {
+	b.Lock()
+	defer b.Unlock()
+	entry, ok := b.entries[address]
+	if !ok {
+		entry = &addressEntry{Address: address}
+		b.entries[address] = entry
+	}
+	entry.LastSeen = time.Now()
+	entry.Failures = 0
+	entry.RetryAt = time.Time{}
+}
+
+// Failed records that the peer at the address did not respond, so it is retried only after a backoff which
+// doubles with every failure. Addresses which keep failing are forgotten.
+func (b *AddressBook) Failed(address string) {
+	b.Lock()
+	defer b.Unlock()
+	entry, ok := b.entries[address]
+	if !ok {
+		return
+	}
+	entry.Failures++
+	if entry.Failures >= maxAddressFailures {
+		delete(b.entries, address)
+		return
+	}
+	entry.RetryAt = time.Now().Add(retryBackoff(entry.Failures))
+}
+
+// Ready returns the addresses which can be tried at the given time, the most recently seen ones first
+func (b *AddressBook) Ready(now time.Time) []string {
+	b.Lock()
+	defer b.Unlock()
+	entries := []*addressEntry{}
+	for _, entry := range b.entries {
+		if !entry.RetryAt.After(now) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+	addresses := make([]string, len(entries))
+	for i, entry := range entries {
+		addresses[i] = entry.Address
+	}
+	return addresses
+}
+
+// Len returns the number of addresses in the book
+func (b *AddressBook) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.entries)
+}
+
+// Load reads the address book from the given file. A missing file is treated as an empty address book.
+func (b *AddressBook) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*addressEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	for _, entry := range entries {
+		if len(b.entries) >= maxAddressBookSize {
+			break
+		}
+		b.entries[entry.Address] = entry
+	}
+	return nil
+}
+
+// Save writes the address book to the given file. The book is written to a temporary file first, so a
+// crash while saving never leaves a partially written book behind.
+func (b *AddressBook) Save(path string) error {
+	b.Lock()
+	entries := make([]*addressEntry, 0, len(b.entries))
+	for _, entry := range b.entries {
+		entries = append(entries, entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	b.Unlock()
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
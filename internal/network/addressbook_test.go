@@ -0,0 +1,56 @@
+package network
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	t.Run("Test backing off from failing addresses", func(t *testing.T) {
+		book := NewAddressBook()
+		book.Add("peer:1")
+		book.Failed("peer:1")
+		if len(book.Ready(time.Now())) != 0 {
+			t.Error("Failed address was ready before backoff passed")
+		}
+		if len(book.Ready(time.Now().Add(initialRetryBackoff+time.Second))) != 1 {
+			t.Error("Failed address was not ready after backoff passed")
+		}
+		if retryBackoff(2) != 2*initialRetryBackoff || retryBackoff(100) != maxRetryBackoff {
+			t.Error("Backoff does not double up to the maximum")
+		}
+	})
+	t.Run("Test forgetting addresses which keep failing", func(t *testing.T) {
+		book := NewAddressBook()
+		book.Add("peer:1")
+		for i := 0; i < maxAddressFailures; i++ {
+			book.Failed("peer:1")
+		}
+		if book.Len() != 0 {
+			t.Error("Address which kept failing was not forgotten")
+		}
+	})
+	t.Run("Test persisting address book", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "peers.json")
+		book := NewAddressBook()
+		book.Seen("peer:1")
+		book.Add("peer:2")
+		book.Failed("peer:2")
+		if err := book.Save(path); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := NewAddressBook()
+		if err := loaded.Load(path); err != nil {
+			t.Fatal(err)
+		}
+		ready := loaded.Ready(time.Now())
+		if loaded.Len() != 2 || len(ready) != 1 || ready[0] != "peer:1" {
+			t.Errorf("Loaded address book differs from saved one: %v", ready)
+		}
+		if err := NewAddressBook().Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+			t.Errorf("Loading missing address book failed: %v", err)
+		}
+	})
+}
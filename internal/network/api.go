@@ -1,92 +1,576 @@
 package network
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
 	"github.com/coocos/cryptocurrency/internal/config"
 )
 
+const (
+	maxBlocksPerPage  = 500
+	maxHeadersPerPage = 2000
+	// maxTransactionsPerPage caps the number of transactions returned per page of address history
+	maxTransactionsPerPage = 100
+)
+
 // Api runs the HTTP API for interacting with the node
 type Api struct {
-	cache  *BlockCache
-	events chan<- interface{}
+	cache      *BlockCache
+	chain      *blockchain.Blockchain
+	peers      *Peers
+	handshakes *handshakes
+	events     chan<- interface{}
 }
 
 // NewApi returns a new instance of the API server
-func NewApi(events chan<- interface{}) *Api {
+func NewApi(chain *blockchain.Blockchain, peers *Peers, events chan<- interface{}) *Api {
 	return &Api{
 		&BlockCache{},
+		chain,
+		peers,
+		newHandshakes(),
 		events,
 	}
 }
 
-func (a *Api) updateCache(block blockchain.Block) {
-	a.cache.AddBlock(block)
+func (a *Api) updateCache(blocks []blockchain.Block) {
+	a.cache.AddBlocks(blocks)
 }
 
-// Serve starts the API
-func (a *Api) Serve() error {
-	// Returns blocks from the blockchain
-	http.HandleFunc("/api/v1/blockchain/", func(w http.ResponseWriter, r *http.Request) {
+// decodeMessage decodes the request body either from the binary encoding into message or from JSON
+// into event, depending on the content type of the request
+func decodeMessage(r *http.Request, event interface{}, message encoding.BinaryUnmarshaler) error {
+	if isBinary(r) {
+		body, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		if err := message.UnmarshalBinary(body); err != nil {
+			return fmt.Errorf("Request is not a valid binary encoding: %v", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+		return errors.New("Request is not valid JSON")
+	}
+	return nil
+}
+
+// parseRange parses the from and limit query parameters of a paginated request. The limit defaults to
+// and is capped at maxLimit.
+func parseRange(r *http.Request, maxLimit int) (int, int, error) {
+	query := r.URL.Query()
+	from, limit := 0, maxLimit
+	if value := query.Get("from"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("From needs to be a non-negative integer")
+		}
+		from = parsed
+	}
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, errors.New("Limit needs to be a positive integer")
+		}
+		if parsed < maxLimit {
+			limit = parsed
+		}
+	}
+	return from, limit, nil
+}
+
+// parseAddress parses the base64 encoded address query parameter
+func parseAddress(r *http.Request) (ed25519.PublicKey, error) {
+	address, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("address"))
+	if err != nil || len(address) != ed25519.PublicKeySize {
+		return nil, errors.New("Address is not a valid base64 encoded public key")
+	}
+	return address, nil
+}
+
+// parseHeight parses the optional height query parameter of the account endpoints. The returned boolean
+// tells whether the parameter was given.
+func parseHeight(r *http.Request) (int, bool, error) {
+	value := r.URL.Query().Get("height")
+	if value == "" {
+		return 0, false, nil
+	}
+	height, err := strconv.Atoi(value)
+	if err != nil || height < 0 {
+		return 0, false, errors.New("Height needs to be a non-negative integer")
+	}
+	return height, true, nil
+}
+
+// historyStatus returns the HTTP status for an error looking up account states
+func historyStatus(err error) int {
+	if err == blockchain.ErrNotArchival {
+		return http.StatusNotImplemented
+	}
+	return http.StatusNotFound
+}
+
+// serveBlock returns the block on the main chain with the number or the hex encoded hash in the query
+func (a *Api) serveBlock(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var block blockchain.Block
+	var found bool
+	if value := query.Get("number"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Number needs to be an integer", http.StatusBadRequest)
+			return
+		}
+		block, found = a.cache.BlockByNumber(number)
+	} else {
+		hash, err := hex.DecodeString(query.Get("hash"))
+		if err != nil || len(hash) != sha256.Size {
+			http.Error(w, "Either number or a hex encoded SHA256 hash is required", http.StatusBadRequest)
+			return
+		}
+		block, found = a.cache.BlockByHash(hash)
+	}
+	if !found {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+	if acceptsBinary(r) {
+		encoded, err := block.MarshalBinary()
+		if err != nil {
+			http.Error(w, "Failed to encode block", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", binaryContentType)
+		w.Write(encoded)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(block)
+}
+
+// serveTransaction returns the transaction on the main chain with the ID in the query
+func (a *Api) serveTransaction(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(r.URL.Query().Get("id"))
+	if err != nil || len(hash) != sha256.Size {
+		http.Error(w, "Transaction ID is not a valid hex encoded SHA256 hash", http.StatusBadRequest)
+		return
+	}
+	block, index, found := a.chain.FindTransaction(hex.EncodeToString(hash))
+	if !found {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTransactionRecord(block, index))
+}
+
+// penaltyFor returns the misbehavior penalty for sending an invalid message which failed with the error
+func penaltyFor(err error) int {
+	switch err {
+	case blockchain.ErrInvalidProofOfWork, blockchain.ErrWrongDifficulty:
+		return penaltyInvalidProofOfWork
+	case blockchain.ErrInvalidSignature:
+		return penaltyInvalidSignature
+	default:
+		return penaltyMalformedMessage
+	}
+}
+
+// rejectBanned refuses to serve requests from banned hosts
+func (a *Api) rejectBanned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.peers.Misbehavior().Banned(hostOf(r.RemoteAddr)) {
+			http.Error(w, "Host is banned", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler returns the HTTP handler serving the API
+func (a *Api) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// Returns blocks from the main chain, either all of them or a page of them when from or limit is given
+	mux.HandleFunc("/api/v1/blockchain/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		blocks := []blockchain.Block{}
-		for block := range a.cache.ReadBlocks() {
-			blocks = append(blocks, block)
+		query := r.URL.Query()
+		if query.Get("from") != "" || query.Get("limit") != "" {
+			from, limit, err := parseRange(r, maxBlocksPerPage)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			blocks = a.cache.ReadRange(from, limit)
+		} else {
+			for block := range a.cache.ReadBlocks() {
+				blocks = append(blocks, block)
+			}
+		}
+		if acceptsBinary(r) {
+			encoded, err := encodeBlocks(blocks)
+			if err != nil {
+				http.Error(w, "Failed to encode blocks", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", binaryContentType)
+			w.Write(encoded)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(blocks)
 	})
-	// Receives new blocks from other nodes
-	http.HandleFunc("/api/v1/block/", func(w http.ResponseWriter, r *http.Request) {
+	// Returns a page of block headers from the main chain
+	mux.HandleFunc("/api/v1/headers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		from, limit, err := parseRange(r, maxHeadersPerPage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		headers := []blockchain.BlockHeader{}
+		for _, block := range a.cache.ReadRange(from, limit) {
+			headers = append(headers, block.Header())
+		}
+		if acceptsBinary(r) {
+			encoded, err := encodeHeaders(headers)
+			if err != nil {
+				http.Error(w, "Failed to encode headers", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", binaryContentType)
+			w.Write(encoded)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(headers)
+	})
+	// Returns the last block of the main chain, which other nodes poll to find out if they are behind
+	mux.HandleFunc("/api/v1/tip/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		block, work := a.chain.Tip()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ChainTip{block.Number, block.Hash, work})
+	})
+	// Returns a block from the main chain by number or hash, and receives new blocks from other nodes
+	mux.HandleFunc("/api/v1/block/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			a.serveBlock(w, r)
+			return
+		}
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var block NewBlock
-		if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
-			http.Error(w, "Request is not valid JSON", http.StatusBadRequest)
+		err := decodeMessage(r, &block, &block.Block)
+		if err == nil {
+			err = block.Block.CheckIntegrity()
+		}
+		if err == nil {
+			err = a.chain.CheckDifficulty(&block.Block)
+		}
+		if err != nil {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyFor(err), err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		block.Source = r.RemoteAddr
 		a.events <- block
 		w.WriteHeader(http.StatusAccepted)
 	})
-	http.HandleFunc("/api/v1/accounts/", func(w http.ResponseWriter, r *http.Request) {
+	// Returns a transaction from the main chain by ID, and receives new transactions from users and other
+	// nodes
+	mux.HandleFunc("/api/v1/transaction/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			a.serveTransaction(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var transaction NewTransaction
+		if err := decodeMessage(r, &transaction, &transaction.Transaction); err != nil {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyMalformedMessage, err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := a.chain.SubmitTransaction(transaction.Transaction)
+		if err == blockchain.ErrInvalidSignature {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyInvalidSignature, err.Error())
+		}
+		// Known transactions are not relayed again so that gossip does not loop between nodes
+		if err == blockchain.ErrKnownTransaction {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		transaction.Source = r.RemoteAddr
+		a.events <- transaction
+		w.WriteHeader(http.StatusAccepted)
+	})
+	// Returns a Merkle proof that a transaction is included in a block on the main chain
+	mux.HandleFunc("/api/v1/proof/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		blocks := []*blockchain.Block{}
-		for block := range a.cache.ReadBlocks() {
-			blocks = append(blocks, &block)
+		hash, err := hex.DecodeString(r.URL.Query().Get("transaction"))
+		if err != nil || len(hash) != sha256.Size {
+			http.Error(w, "Transaction hash is not a valid hex encoded SHA256 hash", http.StatusBadRequest)
+			return
+		}
+		block, index, found := a.chain.FindTransaction(hex.EncodeToString(hash))
+		if !found {
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TransactionProof{
+			Header:          block.Header(),
+			BlockHash:       block.Hash,
+			TransactionHash: hash,
+			Proof:           block.TransactionProof(index),
+		})
+	})
+	// Returns whether a transaction is unknown, waiting in the pool, included in a block on the main chain
+	// or rejected
+	mux.HandleFunc("/api/v1/receipt/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		hash, err := hex.DecodeString(r.URL.Query().Get("transaction"))
+		if err != nil || len(hash) != sha256.Size {
+			http.Error(w, "Transaction ID is not a valid hex encoded SHA256 hash", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(a.chain.TransactionStatus(hex.EncodeToString(hash))); err != nil {
+			log.Println("Failed to serialize transaction status", err)
+		}
+	})
+	// Returns the states of all accounts, either currently or after the block at the given height
+	mux.HandleFunc("/api/v1/accounts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accounts := a.chain.ListAccounts()
+		if height, ok, err := parseHeight(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if ok {
+			if accounts, err = a.chain.ListAccountsAt(height); err != nil {
+				http.Error(w, err.Error(), historyStatus(err))
+				return
+			}
 		}
-		accounts := blockchain.AccountsFromBlockchain(blocks).ListAccounts()
 		w.Header().Set("Content-Type", "application/json")
 		err := json.NewEncoder(w).Encode(accounts)
 		if err != nil {
 			log.Println("Failed to serialize accounts", err)
 		}
 	})
-	// Receives notifications of new peer nodes
-	http.HandleFunc("/api/v1/peer/", func(w http.ResponseWriter, r *http.Request) {
+	// Returns the state of a single account, either currently or after the block at the given height
+	mux.HandleFunc("/api/v1/account/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		address, err := parseAddress(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		height, ok, err := parseHeight(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var account blockchain.Account
+		if ok {
+			account, err = a.chain.AccountAt(address, height)
+		} else {
+			account, err = a.chain.Account(address)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), historyStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(account)
+	})
+	// Returns the transactions of a sender waiting in the pool and the nonce its next transaction should use
+	mux.HandleFunc("/api/v1/pool/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		address, err := parseAddress(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.chain.PooledTransactions(address))
+	})
+	// Returns a page of the transactions sent from or to an address on the main chain, most recent first
+	mux.HandleFunc("/api/v1/history/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		address, err := parseAddress(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, limit, err := parseRange(r, maxTransactionsPerPage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		transactions, total := a.cache.AddressHistory(address, from, limit)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AddressHistory{address, total, transactions})
+	})
+	// Returns the addresses of known peer nodes for peer exchange
+	mux.HandleFunc("/api/v1/peers/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.peers.Addresses())
+	})
+	// Returns what the node has observed about each connected peer, e.g. its latency and advertised height
+	mux.HandleFunc("/api/v1/peers/info/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.peers.Info())
+	})
+	// Returns the misbehavior scores of hosts which have sent invalid messages, including banned hosts
+	mux.HandleFunc("/api/v1/misbehavior/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.peers.Misbehavior().Scores())
+	})
+	// Returns the identity of the node, which other nodes use to check that the node at an address is the
+	// one which completed a handshake with them
+	mux.HandleFunc("/api/v1/identity/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.peers.Identity().Hello(nil))
+	})
+	// Starts a handshake with a node which wants to become a peer by signing its challenge
+	mux.HandleFunc("/api/v1/handshake/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		var peer NewPeer
-		if err := json.NewDecoder(r.Body).Decode(&peer); err != nil {
+		var hello Hello
+		if err := json.NewDecoder(r.Body).Decode(&hello); err != nil {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyMalformedMessage, "Hello is not valid JSON")
 			http.Error(w, "Request is not valid JSON", http.StatusBadRequest)
 			return
 		}
-		a.events <- peer
+		identity := a.peers.Identity()
+		if err := identity.compatible(hello); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if len(hello.Challenge) != challengeSize || len(hello.PublicKey) != ed25519.PublicKeySize {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyMalformedMessage, "Hello is malformed")
+			http.Error(w, "Hello has invalid challenge or public key", http.StatusBadRequest)
+			return
+		}
+		if a.peers.transport.Mutual() && !bytes.Equal(certificateKey(r.TLS), hello.PublicKey) {
+			http.Error(w, "Client certificate does not match identity", http.StatusUnauthorized)
+			return
+		}
+		challenge, err := a.handshakes.start(hello)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HandshakeResponse{identity.Hello(challenge), identity.sign(hello.Challenge)})
+	})
+	// Completes a handshake once the other node has signed the challenge of this node
+	mux.HandleFunc("/api/v1/handshake/confirm/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var confirmation HandshakeConfirmation
+		if err := json.NewDecoder(r.Body).Decode(&confirmation); err != nil {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyMalformedMessage, "Handshake confirmation is not valid JSON")
+			http.Error(w, "Request is not valid JSON", http.StatusBadRequest)
+			return
+		}
+		hello, ok := a.handshakes.finish(confirmation.Challenge)
+		if !ok {
+			http.Error(w, "Handshake is unknown or expired", http.StatusBadRequest)
+			return
+		}
+		if a.peers.transport.Mutual() && !bytes.Equal(certificateKey(r.TLS), hello.PublicKey) {
+			http.Error(w, "Client certificate does not match identity", http.StatusUnauthorized)
+			return
+		}
+		if !a.peers.Identity().verify(hello.PublicKey, confirmation.Challenge, confirmation.Signature) {
+			a.peers.Penalize(hostOf(r.RemoteAddr), penaltyInvalidSignature, "Invalid handshake signature")
+			http.Error(w, "Handshake signature is not valid", http.StatusBadRequest)
+			return
+		}
+		a.events <- NewPeer{hello.Address, hello.PublicKey}
 		w.Write(nil)
 	})
+	return a.rejectBanned(mux)
+}
+
+// Serve starts the API
+func (a *Api) Serve() error {
 	bindHost := config.BindHost()
-	log.Println("Listening for API requests at", bindHost)
-	return http.ListenAndServe(bindHost, nil)
+	if a.peers.transport.Secure() {
+		log.Println("Listening for API requests over TLS at", bindHost)
+	} else {
+		log.Println("Listening for API requests at", bindHost)
+	}
+	return a.peers.transport.Serve(bindHost, a.Handler())
 }
@@ -0,0 +1,166 @@
+package network
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestApi(t *testing.T) {
+	t.Run("Test relaying transaction replacements", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		chain := blockchain.NewBlockchain(sender, blockchain.WithGenesis(testGenesis()))
+		chain.MineBlock()
+		address, events := serveNode(t, chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		client := NewNodeClient(NewHTTPTransport(), address)
+
+		for _, fee := range []uint{1, 2} {
+			transaction := blockchain.NewTransaction(sender.PublicKey, sender.PublicKey, 0, fee, 1)
+			transaction.Sign(sender.PrivateKey)
+			if err := client.SendTransaction(*transaction); err != nil {
+				t.Fatal(err)
+			}
+			relayed, ok := (<-events).(NewTransaction)
+			if !ok || !bytes.Equal(relayed.Transaction.Signature, transaction.Signature) {
+				t.Fatalf("Transaction with fee %d was not relayed", fee)
+			}
+		}
+
+		underpriced := blockchain.NewTransaction(sender.PublicKey, sender.PublicKey, 0, 2, 1)
+		underpriced.Sign(sender.PrivateKey)
+		if err := client.SendTransaction(*underpriced); err == nil {
+			t.Error("Replacement without fee bump was accepted")
+		}
+		if len(events) != 0 {
+			t.Error("Replacement without fee bump was relayed")
+		}
+	})
+	t.Run("Test querying transaction status", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		chain := blockchain.NewBlockchain(sender, blockchain.WithGenesis(testGenesis()))
+		chain.MineBlock()
+		client := NewNodeClient(NewHTTPTransport(), serveTestChain(t, chain))
+
+		transaction := blockchain.NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, 1, 1)
+		transaction.Sign(sender.PrivateKey)
+		if status, err := client.GetTransactionStatus(transaction.ID()); err != nil || status.Status != blockchain.StatusUnknown {
+			t.Fatalf("Expected unknown status but got %+v: %v", status, err)
+		}
+		client.SendTransaction(*transaction)
+		if status, err := client.GetTransactionStatus(transaction.ID()); err != nil || status.Status != blockchain.StatusPending {
+			t.Fatalf("Expected pending status but got %+v: %v", status, err)
+		}
+		if _, err := client.GetTransactionStatus("not-an-id"); err == nil {
+			t.Error("Invalid transaction ID was accepted")
+		}
+	})
+	t.Run("Test looking up blocks, transactions and accounts", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		chain := blockchain.NewBlockchain(sender, blockchain.WithGenesis(testGenesis()))
+		chain.MineBlock()
+		transaction := blockchain.NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, 1, 1)
+		transaction.Sign(sender.PrivateKey)
+		chain.AddTransaction(*transaction)
+		chain.MineBlock()
+		client := NewNodeClient(NewHTTPTransport(), serveTestChain(t, chain))
+
+		for _, query := range []string{"number=2", "hash=" + hex.EncodeToString(chain.LastBlock().Hash)} {
+			var block blockchain.Block
+			if err := getJSON(client, "/block/?"+query, &block); err != nil || block.Number != 2 {
+				t.Errorf("Block was not found by %s: %v", query, err)
+			}
+		}
+		var record TransactionRecord
+		if err := getJSON(client, "/transaction/?id="+transaction.ID(), &record); err != nil || record.Block != 2 {
+			t.Errorf("Transaction was not found by ID: %v", err)
+		}
+		account, found, err := client.GetAccount(sender.PublicKey)
+		if err != nil || !found || account.Nonce != 1 {
+			t.Errorf("Account was not found by address: %+v %v", account, err)
+		}
+		if _, found, err := client.GetAccount(keys.NewKeyPair().PublicKey); err != nil || found {
+			t.Errorf("Unknown account was found: %v", err)
+		}
+		history, err := client.GetHistory(sender.PublicKey, 0, 1)
+		if err != nil || history.Total != 3 || len(history.Transactions) != 1 || history.Transactions[0].ID != transaction.ID() {
+			t.Errorf("Unexpected history: %+v %v", history, err)
+		}
+	})
+	t.Run("Test querying account states at past heights", func(t *testing.T) {
+		miner := keys.NewKeyPair()
+		archival := blockchain.NewBlockchain(miner, blockchain.WithGenesis(testGenesis()), blockchain.WithArchive(2))
+		for i := 0; i < 3; i++ {
+			archival.MineBlock()
+		}
+		client := NewNodeClient(NewHTTPTransport(), serveTestChain(t, archival))
+		address := url.QueryEscape(base64.StdEncoding.EncodeToString(miner.PublicKey))
+
+		var account blockchain.Account
+		if err := getJSON(client, "/account/?height=1&address="+address, &account); err != nil || account.Balance != blockchain.CoinbaseTransactionAmount {
+			t.Errorf("Unexpected account state at height 1: %+v %v", account, err)
+		}
+		var accounts []blockchain.Account
+		if err := getJSON(client, "/accounts/?height=2", &accounts); err != nil || len(accounts) != 1 || accounts[0].Balance != 2*blockchain.CoinbaseTransactionAmount {
+			t.Errorf("Unexpected account states at height 2: %+v %v", accounts, err)
+		}
+		if err := getJSON(client, "/account/?height=4&address="+address, &account); err == nil {
+			t.Error("Account state above tip was returned")
+		}
+
+		client = NewNodeClient(NewHTTPTransport(), serveTestChain(t, mineTestChain(1)))
+		if err := getJSON(client, "/accounts/?height=1", &accounts); err == nil {
+			t.Error("Past account states were returned without archival mode")
+		}
+	})
+	t.Run("Test listing pooled transactions of sender", func(t *testing.T) {
+		sender := keys.NewKeyPair()
+		chain := blockchain.NewBlockchain(sender, blockchain.WithGenesis(testGenesis()))
+		chain.MineBlock()
+		client := NewNodeClient(NewHTTPTransport(), serveTestChain(t, chain))
+
+		for _, nonce := range []uint{1, 2, 4} {
+			transaction := blockchain.NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, 2, nonce)
+			transaction.Sign(sender.PrivateKey)
+			if err := client.SendTransaction(*transaction); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pooled, err := client.GetPooledTransactions(sender.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pooled.NextNonce != 3 || len(pooled.Transactions) != 3 {
+			t.Fatalf("Unexpected pooled transactions: %+v", pooled)
+		}
+		if first := pooled.Transactions[0]; first.Status != blockchain.StatusPending || first.ReplacementFee != 3 {
+			t.Errorf("Unexpected pooled transaction: %+v", first)
+		}
+		if last := pooled.Transactions[2]; last.Status != blockchain.StatusQueued {
+			t.Errorf("Transaction after nonce gap is not queued: %+v", last)
+		}
+		if pooled, err := client.GetPooledTransactions(keys.NewKeyPair().PublicKey); err != nil || pooled.NextNonce != 1 {
+			t.Errorf("Unexpected next nonce for new account: %+v %v", pooled, err)
+		}
+	})
+}
+
+// getJSON requests a resource from the node and decodes the JSON response
+func getJSON(client *NodeClient, resource string, value interface{}) error {
+	response, err := client.get(resource)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d", response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
@@ -1,24 +1,145 @@
 package network
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"sync"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
 )
 
-// BlockCache is a synchronized cache for blocks
+// BlockCache is a synchronized cache for the blocks of the main chain. The blocks are indexed by hash and
+// the transactions of each address in chain order, so that they can be looked up without scanning the
+// whole chain. Transactions are looked up by ID from the blockchain itself, which keeps them indexed
+// along with the main chain.
 type BlockCache struct {
 	sync.RWMutex
-	blocks []blockchain.Block
+	blocks    []blockchain.Block
+	hashes    map[string]int
+	addresses map[string][]transactionLocation
+}
+
+// transactionLocation locates a transaction on the main chain by block number and index within the block
+type transactionLocation struct {
+	Block int
+	Index int
 }
 
 // AddBlock adds a block to the cache
 func (b *BlockCache) AddBlock(block blockchain.Block) {
 	b.Lock()
-	b.blocks = append(b.blocks, block)
+	b.append(block)
 	b.Unlock()
 }
 
+// AddBlocks adds consecutive blocks to the cache, replacing any cached blocks from the same height onwards
+func (b *BlockCache) AddBlocks(blocks []blockchain.Block) {
+	if len(blocks) == 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if height := blocks[0].Number; height < len(b.blocks) {
+		b.truncate(height)
+	}
+	for _, block := range blocks {
+		b.append(block)
+	}
+}
+
+// append adds the block to the end of the cache and indexes it
+func (b *BlockCache) append(block blockchain.Block) {
+	if b.hashes == nil {
+		b.hashes = make(map[string]int)
+		b.addresses = make(map[string][]transactionLocation)
+	}
+	b.blocks = append(b.blocks, block)
+	b.hashes[hex.EncodeToString(block.Hash)] = block.Number
+	for index, transaction := range block.Transactions {
+		location := transactionLocation{block.Number, index}
+		for _, address := range involved(transaction) {
+			b.addresses[address] = append(b.addresses[address], location)
+		}
+	}
+}
+
+// truncate removes the blocks from the given height onwards along with their index entries
+func (b *BlockCache) truncate(height int) {
+	for _, block := range b.blocks[height:] {
+		delete(b.hashes, hex.EncodeToString(block.Hash))
+		for _, transaction := range block.Transactions {
+			for _, address := range involved(transaction) {
+				locations := b.addresses[address]
+				for len(locations) > 0 && locations[len(locations)-1].Block >= height {
+					locations = locations[:len(locations)-1]
+				}
+				if len(locations) == 0 {
+					delete(b.addresses, address)
+				} else {
+					b.addresses[address] = locations
+				}
+			}
+		}
+	}
+	b.blocks = b.blocks[:height]
+}
+
+// involved returns the base64 encoded addresses of the sender and the receiver of the transaction
+func involved(transaction blockchain.Transaction) []string {
+	receiver := base64.StdEncoding.EncodeToString(transaction.Receiver)
+	if transaction.Sender == nil || bytes.Equal(transaction.Sender, transaction.Receiver) {
+		return []string{receiver}
+	}
+	return []string{base64.StdEncoding.EncodeToString(transaction.Sender), receiver}
+}
+
+// HasBlock indicates whether the cache contains a block with the given number and hash
+func (b *BlockCache) HasBlock(number int, hash []byte) bool {
+	b.RLock()
+	defer b.RUnlock()
+	return number < len(b.blocks) && bytes.Equal(b.blocks[number].Hash, hash)
+}
+
+// BlockByNumber returns the block with the given number on the main chain
+func (b *BlockCache) BlockByNumber(number int) (blockchain.Block, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	if number < 0 || number >= len(b.blocks) {
+		return blockchain.Block{}, false
+	}
+	return b.blocks[number], true
+}
+
+// BlockByHash returns the block with the given hash on the main chain
+func (b *BlockCache) BlockByHash(hash []byte) (blockchain.Block, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	number, exists := b.hashes[hex.EncodeToString(hash)]
+	if !exists {
+		return blockchain.Block{}, false
+	}
+	return b.blocks[number], true
+}
+
+// AddressHistory returns at most limit transactions sent from or to the address, skipping the given
+// number of the most recent ones, along with the total number of transactions of the address. The most
+// recent transactions come first.
+func (b *BlockCache) AddressHistory(address []byte, skip int, limit int) ([]TransactionRecord, int) {
+	b.RLock()
+	defer b.RUnlock()
+	locations := b.addresses[base64.StdEncoding.EncodeToString(address)]
+	records := []TransactionRecord{}
+	for i := len(locations) - 1 - skip; i >= 0 && len(records) < limit; i-- {
+		records = append(records, b.record(locations[i]))
+	}
+	return records, len(locations)
+}
+
+func (b *BlockCache) record(location transactionLocation) TransactionRecord {
+	return newTransactionRecord(b.blocks[location.Block], location.Index)
+}
+
 // ReadBlock returns a block from the cache
 func (b *BlockCache) ReadLastBlock() blockchain.Block {
 	b.RLock()
@@ -27,6 +148,20 @@ func (b *BlockCache) ReadLastBlock() blockchain.Block {
 	return block
 }
 
+// ReadRange returns at most limit consecutive blocks starting from the given number
+func (b *BlockCache) ReadRange(from int, limit int) []blockchain.Block {
+	b.RLock()
+	defer b.RUnlock()
+	if from < 0 || from >= len(b.blocks) || limit <= 0 {
+		return []blockchain.Block{}
+	}
+	to := len(b.blocks)
+	if to-from > limit {
+		to = from + limit
+	}
+	return append([]blockchain.Block{}, b.blocks[from:to]...)
+}
+
 // ReadBlocks returns a channel for iterating over all the blocks in the cache
 func (b *BlockCache) ReadBlocks() <-chan blockchain.Block {
 	blocks := make(chan blockchain.Block)
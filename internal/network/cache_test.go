@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
 )
 
 func TestCache(t *testing.T) {
@@ -17,4 +18,57 @@ func TestCache(t *testing.T) {
 			t.Error("Cache returned wrong block")
 		}
 	})
+	t.Run("Test replacing reorganized blocks in cache", func(t *testing.T) {
+		cache := &BlockCache{}
+		genesis := *blockchain.GenesisBlock()
+		orphaned := blockchain.Block{Number: 1, PreviousHash: genesis.Hash, Hash: []byte{1}}
+		replacement := blockchain.Block{Number: 1, PreviousHash: genesis.Hash, Hash: []byte{2}}
+		cache.AddBlocks([]blockchain.Block{genesis, orphaned})
+		cache.AddBlocks([]blockchain.Block{replacement})
+
+		if !cache.HasBlock(replacement.Number, replacement.Hash) {
+			t.Error("Cache does not contain replacement block")
+		}
+		if cache.HasBlock(orphaned.Number, orphaned.Hash) {
+			t.Error("Cache still contains orphaned block")
+		}
+		if !cache.HasBlock(genesis.Number, genesis.Hash) {
+			t.Error("Cache lost block preceding the replaced blocks")
+		}
+	})
+	t.Run("Test indexing blocks, transactions and addresses", func(t *testing.T) {
+		miner := keys.NewKeyPair()
+		receiver := keys.NewKeyPair()
+		chain := blockchain.NewBlockchain(miner, blockchain.WithGenesis(testGenesis()))
+		chain.MineBlock()
+		transaction := blockchain.NewTransaction(miner.PublicKey, receiver.PublicKey, 1, 0, 1)
+		transaction.Sign(miner.PrivateKey)
+		chain.AddTransaction(*transaction)
+		chain.MineBlock()
+		cache := &BlockCache{}
+		cache.AddBlocks(mainChain(chain))
+
+		block, found := cache.BlockByHash(chain.LastBlock().Hash)
+		if !found || block.Number != 2 {
+			t.Error("Block was not found by hash")
+		}
+		if block, found := cache.BlockByNumber(1); !found || block.Number != 1 {
+			t.Error("Block was not found by number")
+		}
+		history, total := cache.AddressHistory(miner.PublicKey, 0, 2)
+		if total != 3 || len(history) != 2 || history[0].ID != transaction.ID() {
+			t.Errorf("Unexpected history of %d transactions: %+v", total, history)
+		}
+		if history, _ := cache.AddressHistory(miner.PublicKey, 2, 2); len(history) != 1 || history[0].Block != 1 {
+			t.Errorf("Unexpected second page of history: %+v", history)
+		}
+
+		cache.AddBlocks([]blockchain.Block{{Number: 2, PreviousHash: block.PreviousHash, Hash: []byte{2}}})
+		if _, total := cache.AddressHistory(receiver.PublicKey, 0, 10); total != 0 {
+			t.Error("Address history still contains orphaned transaction")
+		}
+		if _, found := cache.BlockByHash(block.Hash); found {
+			t.Error("Orphaned block is still indexed by hash")
+		}
+	})
 }
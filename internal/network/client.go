@@ -2,45 +2,261 @@ package network
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
-	"github.com/coocos/cryptocurrency/internal/config"
 )
 
 // NodeClient is an HTTP client used to communicate with a node
 type NodeClient struct {
+	transport   Transport
 	peerAddress string
+	// publicKey is the identity of the node if it is known, in which case the TLS certificate of the node
+	// needs to be for that identity
+	publicKey ed25519.PublicKey
+}
+
+// NewNodeClient returns a client for the node at the given address which uses the given transport
+func NewNodeClient(transport Transport, address string) *NodeClient {
+	return &NodeClient{transport: transport, peerAddress: address}
+}
+
+// PinIdentity requires the TLS certificate of the node to be for the identity with the given public key.
+// Clients without an identity of their own, e.g. wallets, need to pin the identity of the node to know
+// they are talking to it, since the certificates of nodes are self-signed.
+func (c *NodeClient) PinIdentity(publicKey ed25519.PublicKey) {
+	c.publicKey = publicKey
 }
 
 func (c *NodeClient) apiUrl(resource string) string {
-	return fmt.Sprintf("http://%s/api/v1%s", c.peerAddress, resource)
+	scheme := "http"
+	if c.transport.Secure() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1%s", scheme, c.peerAddress, resource)
+}
+
+// do sends the request to peer node and checks that the connection is bound to the identity of peer node
+// if it is known
+func (c *NodeClient) do(request *http.Request) (*http.Response, error) {
+	response, err := c.transport.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if c.publicKey != nil && !matchesCertificate(response.TLS, c.publicKey, c.transport.Secure()) {
+		response.Body.Close()
+		return nil, fmt.Errorf("Certificate of %s does not match its identity", c.peerAddress)
+	}
+	return response, nil
+}
+
+// get requests a resource from peer node
+func (c *NodeClient) get(resource string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, c.apiUrl(resource), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(request)
+}
+
+// post sends the payload to a resource of peer node
+func (c *NodeClient) post(resource string, contentType string, payload []byte) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodPost, c.apiUrl(resource), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	return c.do(request)
+}
+
+// getBinary requests a binary encoded resource from peer node
+func (c *NodeClient) getBinary(resource string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, c.apiUrl(resource), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", binaryContentType)
+	response, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get %s from %s: %v %s", resource, c.peerAddress, response.StatusCode, bytes.TrimSpace(payload))
+	}
+	return payload, nil
 }
 
 // GetBlocks requests all known blocks from peer node
 func (c *NodeClient) GetBlocks() ([]blockchain.Block, error) {
-	response, err := http.Get(c.apiUrl("/blockchain/"))
+	payload, err := c.getBinary("/blockchain/")
 	if err != nil {
 		return nil, err
 	}
+	return decodeBlocks(payload)
+}
+
+// GetBlockRange requests at most limit blocks starting from the given number from peer node
+func (c *NodeClient) GetBlockRange(from int, limit int) ([]blockchain.Block, error) {
+	payload, err := c.getBinary(fmt.Sprintf("/blockchain/?from=%d&limit=%d", from, limit))
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlocks(payload)
+}
+
+// GetHeaders requests at most limit block headers starting from the given number from peer node
+func (c *NodeClient) GetHeaders(from int, limit int) ([]blockchain.BlockHeader, error) {
+	payload, err := c.getBinary(fmt.Sprintf("/headers/?from=%d&limit=%d", from, limit))
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeaders(payload)
+}
+
+// GetTip requests the last block of the main chain from peer node
+func (c *NodeClient) GetTip() (ChainTip, error) {
+	response, err := c.get("/tip/")
+	if err != nil {
+		return ChainTip{}, err
+	}
 	defer response.Body.Close()
-	var blocks []blockchain.Block
-	if err := json.NewDecoder(response.Body).Decode(&blocks); err != nil {
+	if response.StatusCode != http.StatusOK {
+		return ChainTip{}, fmt.Errorf("Failed to get tip from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var tip ChainTip
+	if err := json.NewDecoder(response.Body).Decode(&tip); err != nil {
+		return ChainTip{}, err
+	}
+	return tip, nil
+}
+
+// GetTransactionStatus requests the status of the transaction with the given ID from peer node
+func (c *NodeClient) GetTransactionStatus(id string) (blockchain.TransactionStatus, error) {
+	response, err := c.get("/receipt/?transaction=" + id)
+	if err != nil {
+		return blockchain.TransactionStatus{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(response.Body)
+		return blockchain.TransactionStatus{}, fmt.Errorf("Failed to get transaction status from %s: %v %s", c.peerAddress, response.StatusCode, bytes.TrimSpace(reason))
+	}
+	var status blockchain.TransactionStatus
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return blockchain.TransactionStatus{}, err
+	}
+	return status, nil
+}
+
+// GetPeers requests the addresses of the peers known by peer node
+func (c *NodeClient) GetPeers() ([]string, error) {
+	response, err := c.get("/peers/")
+	if err != nil {
 		return nil, err
 	}
-	return blocks, nil
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get peers from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var addresses []string
+	if err := json.NewDecoder(response.Body).Decode(&addresses); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// GetAccounts requests the current state of all accounts from node
+func (c *NodeClient) GetAccounts() ([]blockchain.Account, error) {
+	response, err := c.get("/accounts/")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var accounts []blockchain.Account
+	if err := json.NewDecoder(response.Body).Decode(&accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetAccount requests the state of the account with the given address from peer node. The returned
+// boolean is false if the account does not exist yet.
+func (c *NodeClient) GetAccount(address ed25519.PublicKey) (blockchain.Account, bool, error) {
+	response, err := c.get("/account/?address=" + url.QueryEscape(base64.StdEncoding.EncodeToString(address)))
+	if err != nil {
+		return blockchain.Account{}, false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return blockchain.Account{}, false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return blockchain.Account{}, false, fmt.Errorf("Failed to get account from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var account blockchain.Account
+	if err := json.NewDecoder(response.Body).Decode(&account); err != nil {
+		return blockchain.Account{}, false, err
+	}
+	return account, true, nil
+}
+
+// GetPooledTransactions requests the transactions of the sender waiting in the pool of peer node along
+// with the nonce the next transaction of the sender should use
+func (c *NodeClient) GetPooledTransactions(sender ed25519.PublicKey) (blockchain.SenderTransactions, error) {
+	response, err := c.get("/pool/?address=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sender)))
+	if err != nil {
+		return blockchain.SenderTransactions{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return blockchain.SenderTransactions{}, fmt.Errorf("Failed to get pooled transactions from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var pooled blockchain.SenderTransactions
+	if err := json.NewDecoder(response.Body).Decode(&pooled); err != nil {
+		return blockchain.SenderTransactions{}, err
+	}
+	return pooled, nil
+}
+
+// GetHistory requests at most limit transactions sent from or to the address from peer node, skipping
+// the given number of the most recent ones
+func (c *NodeClient) GetHistory(address ed25519.PublicKey, from int, limit int) (AddressHistory, error) {
+	encoded := url.QueryEscape(base64.StdEncoding.EncodeToString(address))
+	response, err := c.get(fmt.Sprintf("/history/?address=%s&from=%d&limit=%d", encoded, from, limit))
+	if err != nil {
+		return AddressHistory{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return AddressHistory{}, fmt.Errorf("Failed to get history from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var history AddressHistory
+	if err := json.NewDecoder(response.Body).Decode(&history); err != nil {
+		return AddressHistory{}, err
+	}
+	return history, nil
 }
 
 // SendBlock sends block to peer node
 func (c *NodeClient) SendBlock(block blockchain.Block) error {
-	newBlock := NewBlock{block}
-	payload, err := json.Marshal(newBlock)
+	payload, err := block.MarshalBinary()
 	if err != nil {
 		return err
 	}
-	response, err := http.Post(c.apiUrl("/block/"), "application/json", bytes.NewBuffer(payload))
+	response, err := c.post("/block/", binaryContentType, payload)
 	if err != nil {
 		return err
 	}
@@ -51,20 +267,92 @@ func (c *NodeClient) SendBlock(block blockchain.Block) error {
 	return nil
 }
 
-// Greet sends a greeting to peer node
-func (c *NodeClient) Greet() error {
-	greeting := NewPeer{config.AdvertisedHost()}
-	payload, err := json.Marshal(greeting)
+// SendTransaction sends transaction to peer node
+func (c *NodeClient) SendTransaction(transaction blockchain.Transaction) error {
+	payload, err := transaction.MarshalBinary()
 	if err != nil {
 		return err
 	}
-	response, err := http.Post(c.apiUrl("/peer/"), "application/json", bytes.NewBuffer(payload))
+	response, err := c.post("/transaction/", binaryContentType, payload)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("Greeting response: %v", response.StatusCode)
+	if response.StatusCode != http.StatusAccepted && response.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("Failed to send transaction to %s: %v %s", c.peerAddress, response.StatusCode, bytes.TrimSpace(reason))
 	}
 	return nil
 }
+
+// postJSON posts the message as JSON to peer node and decodes the JSON response into result if it is given.
+// The TLS state of the connection is returned so that the certificate of peer node can be checked.
+func (c *NodeClient) postJSON(resource string, message interface{}, result interface{}) (*tls.ConnectionState, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.post(resource, "application/json", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		reason, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("Failed to post %s to %s: %v %s", resource, c.peerAddress, response.StatusCode, bytes.TrimSpace(reason))
+	}
+	if result == nil {
+		return response.TLS, nil
+	}
+	return response.TLS, json.NewDecoder(response.Body).Decode(result)
+}
+
+// Handshake introduces the node with the given identity to peer node. Both nodes sign a challenge of the
+// other to prove their identities. The hello of peer node is returned once it has verified this node.
+func (c *NodeClient) Handshake(identity *Identity) (Hello, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return Hello{}, err
+	}
+	var response HandshakeResponse
+	state, err := c.postJSON("/handshake/", identity.Hello(challenge), &response)
+	if err != nil {
+		return Hello{}, err
+	}
+	hello := response.Hello
+	if err := identity.compatible(hello); err != nil {
+		return Hello{}, err
+	}
+	if bytes.Equal(hello.PublicKey, identity.PublicKey()) {
+		return Hello{}, errors.New("Peer has the identity of this node")
+	}
+	if !identity.verify(hello.PublicKey, challenge, response.Signature) {
+		return Hello{}, errors.New("Peer failed to sign handshake challenge")
+	}
+	if !matchesCertificate(state, hello.PublicKey, c.transport.Secure()) {
+		return Hello{}, errors.New("Peer certificate does not match its identity")
+	}
+	c.publicKey = hello.PublicKey
+	confirmation := HandshakeConfirmation{hello.Challenge, identity.sign(hello.Challenge)}
+	if _, err := c.postJSON("/handshake/confirm/", confirmation, nil); err != nil {
+		return Hello{}, err
+	}
+	return hello, nil
+}
+
+// GetIdentity requests the identity of peer node
+func (c *NodeClient) GetIdentity() (Hello, error) {
+	response, err := c.get("/identity/")
+	if err != nil {
+		return Hello{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Hello{}, fmt.Errorf("Failed to get identity from %s: %v", c.peerAddress, response.StatusCode)
+	}
+	var hello Hello
+	if err := json.NewDecoder(response.Body).Decode(&hello); err != nil {
+		return Hello{}, err
+	}
+	return hello, nil
+}
@@ -1,18 +1,94 @@
 package network
 
-import "github.com/coocos/cryptocurrency/internal/blockchain"
+import (
+	"crypto/ed25519"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+)
 
 // NewBlockEvent indicates a peer has mined a new block
 type NewBlock struct {
 	Block blockchain.Block `json:"block"`
+	// Source is the address of the host which delivered the block
+	Source string `json:"-"`
 }
 
 // NewTransaction indicates a peer has received a new transaction
 type NewTransaction struct {
 	Transaction blockchain.Transaction `json:"transaction"`
+	// Source is the address of the host which delivered the transaction
+	Source string `json:"-"`
 }
 
-// NewPeer indicates a new peer has been discovered
+// NewPeer indicates a node has completed a handshake and wants to become a peer
 type NewPeer struct {
-	Address string `json:"peerAddress"`
+	Address   string            `json:"peerAddress"`
+	PublicKey ed25519.PublicKey `json:"publicKey"`
+}
+
+// Hello introduces a node during a handshake. The other node needs to sign the challenge with its
+// identity key to prove it owns the identity it claims.
+type Hello struct {
+	Version     int    `json:"version"`
+	ChainID     string `json:"chainId"`
+	GenesisHash []byte `json:"genesisHash"`
+	Address     string `json:"address"`
+	PublicKey   []byte `json:"publicKey"`
+	Challenge   []byte `json:"challenge"`
+}
+
+// HandshakeResponse is the response of a node to a hello. It signs the challenge of the hello and sends
+// a challenge of its own.
+type HandshakeResponse struct {
+	Hello     Hello  `json:"hello"`
+	Signature []byte `json:"signature"`
+}
+
+// HandshakeConfirmation completes a handshake by signing the challenge of the responding node
+type HandshakeConfirmation struct {
+	Challenge []byte `json:"challenge"`
+	Signature []byte `json:"signature"`
+}
+
+// TransactionProof proves that a transaction is included in a block with the given header
+type TransactionProof struct {
+	Header          blockchain.BlockHeader  `json:"header"`
+	BlockHash       []byte                  `json:"blockHash"`
+	TransactionHash []byte                  `json:"transactionHash"`
+	Proof           []blockchain.MerkleStep `json:"proof"`
+}
+
+// TransactionRecord is a transaction on the main chain along with the block it is included in
+type TransactionRecord struct {
+	ID          string                 `json:"id"`
+	Block       int                    `json:"block"`
+	BlockHash   []byte                 `json:"blockHash"`
+	Index       int                    `json:"index"`
+	Transaction blockchain.Transaction `json:"transaction"`
+}
+
+// newTransactionRecord returns a record of the transaction at the given index of the block
+func newTransactionRecord(block blockchain.Block, index int) TransactionRecord {
+	transaction := block.Transactions[index]
+	return TransactionRecord{
+		ID:          transaction.ID(),
+		Block:       block.Number,
+		BlockHash:   block.Hash,
+		Index:       index,
+		Transaction: transaction,
+	}
+}
+
+// AddressHistory is a page of the transactions sent from or to an address, most recent first
+type AddressHistory struct {
+	Address      []byte              `json:"address"`
+	Total        int                 `json:"total"`
+	Transactions []TransactionRecord `json:"transactions"`
+}
+
+// ChainTip describes the last block of the main chain of a node
+type ChainTip struct {
+	Number int    `json:"number"`
+	Hash   []byte `json:"hash"`
+	Work   uint64 `json:"work"`
 }
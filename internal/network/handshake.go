@@ -0,0 +1,163 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// ProtocolVersion is the version of the protocol nodes use to communicate. Nodes refuse peers which use
+// another version.
+const ProtocolVersion = 1
+
+const (
+	challengeSize = 32
+	// handshakeDomain separates handshake signatures from any other signatures made with the same key
+	handshakeDomain = "cryptocurrency handshake"
+	// handshakeTimeout is how long a node waits for a handshake it responded to to be confirmed
+	handshakeTimeout     = 30 * time.Second
+	maxPendingHandshakes = 1024
+)
+
+// Identity identifies a node to its peers. Nodes prove they own their identity by signing challenges
+// with the identity key, and only accept peers on the same network, i.e. with the same protocol version,
+// chain ID and genesis block.
+type Identity struct {
+	keyPair     *keys.KeyPair
+	chainID     string
+	genesisHash []byte
+	address     string
+}
+
+// NewIdentity returns the identity of a node advertised at the given address
+func NewIdentity(keyPair *keys.KeyPair, chainID string, genesisHash []byte, address string) *Identity {
+	return &Identity{
+		keyPair:     keyPair,
+		chainID:     chainID,
+		genesisHash: genesisHash,
+		address:     address,
+	}
+}
+
+// PublicKey returns the public identity key of the node
+func (i *Identity) PublicKey() ed25519.PublicKey {
+	return i.keyPair.PublicKey
+}
+
+// Hello returns a hello introducing the node with the given challenge
+func (i *Identity) Hello(challenge []byte) Hello {
+	return Hello{
+		Version:     ProtocolVersion,
+		ChainID:     i.chainID,
+		GenesisHash: i.genesisHash,
+		Address:     i.address,
+		PublicKey:   i.keyPair.PublicKey,
+		Challenge:   challenge,
+	}
+}
+
+// compatible checks that the node which sent the hello is on the same network
+func (i *Identity) compatible(hello Hello) error {
+	if hello.Version != ProtocolVersion {
+		return fmt.Errorf("Node uses protocol version %d instead of %d", hello.Version, ProtocolVersion)
+	}
+	if hello.ChainID != i.chainID {
+		return fmt.Errorf("Node is on chain %q instead of %q", hello.ChainID, i.chainID)
+	}
+	if !bytes.Equal(hello.GenesisHash, i.genesisHash) {
+		return fmt.Errorf("Node has genesis block %x instead of %x", hello.GenesisHash, i.genesisHash)
+	}
+	return nil
+}
+
+// handshakeMessage returns the message signed to answer a challenge. The message covers the network, so a
+// signature made for one network can not be used on another.
+func (i *Identity) handshakeMessage(challenge []byte) []byte {
+	message := []byte(handshakeDomain)
+	message = append(message, i.genesisHash...)
+	message = append(message, challenge...)
+	return append(message, i.chainID...)
+}
+
+// sign answers the challenge with the identity key
+func (i *Identity) sign(challenge []byte) []byte {
+	return ed25519.Sign(i.keyPair.PrivateKey, i.handshakeMessage(challenge))
+}
+
+// verify checks that the challenge was answered by the owner of the public key
+func (i *Identity) verify(publicKey []byte, challenge []byte, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(publicKey, i.handshakeMessage(challenge), signature)
+}
+
+// newChallenge returns a random challenge
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// pendingHandshake is a handshake which a node has responded to but which has not been confirmed yet
+type pendingHandshake struct {
+	hello   Hello
+	expires time.Time
+}
+
+// handshakes keeps track of pending handshakes by the challenge sent to the other node
+type handshakes struct {
+	sync.Mutex
+	pending map[string]pendingHandshake
+}
+
+func newHandshakes() *handshakes {
+	return &handshakes{pending: make(map[string]pendingHandshake)}
+}
+
+// start records a handshake initiated with the hello and returns the challenge for the other node
+func (h *handshakes) start(hello Hello) ([]byte, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return nil, err
+	}
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	for key, handshake := range h.pending {
+		if now.After(handshake.expires) {
+			delete(h.pending, key)
+		}
+	}
+	if len(h.pending) >= maxPendingHandshakes {
+		return nil, errors.New("Too many pending handshakes")
+	}
+	h.pending[hex.EncodeToString(challenge)] = pendingHandshake{hello, now.Add(handshakeTimeout)}
+	return challenge, nil
+}
+
+// finish returns the hello of the handshake the challenge was sent for. Every challenge can only be
+// answered once.
+func (h *handshakes) finish(challenge []byte) (Hello, bool) {
+	h.Lock()
+	defer h.Unlock()
+	key := hex.EncodeToString(challenge)
+	handshake, ok := h.pending[key]
+	if !ok {
+		return Hello{}, false
+	}
+	delete(h.pending, key)
+	if time.Now().After(handshake.expires) {
+		return Hello{}, false
+	}
+	return handshake.hello, true
+}
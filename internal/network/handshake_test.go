@@ -0,0 +1,89 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestHandshake(t *testing.T) {
+	t.Run("Test completing handshake", func(t *testing.T) {
+		server := testIdentity()
+		address, events := servePeers(t, NewPeers(server, NewHTTPTransport(), 8, 8))
+		client := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		client.Add(address)
+
+		info := client.Info()
+		if len(info) != 1 || !bytes.Equal(info[0].PublicKey, server.PublicKey()) {
+			t.Fatalf("Peer was not added with its identity: %+v", info)
+		}
+		peer, ok := (<-events).(NewPeer)
+		if !ok || !bytes.Equal(peer.PublicKey, client.Identity().PublicKey()) {
+			t.Errorf("Server did not accept identity of client: %+v", peer)
+		}
+	})
+	t.Run("Test refusing nodes on other networks", func(t *testing.T) {
+		address, _ := servePeers(t, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		for _, identity := range []*Identity{
+			NewIdentity(keys.NewKeyPair(), "other", testGenesis().Hash, "127.0.0.1:1"),
+			NewIdentity(keys.NewKeyPair(), "test", make([]byte, 32), "127.0.0.1:1"),
+		} {
+			peers := NewPeers(identity, NewHTTPTransport(), 8, 8)
+			peers.Add(address)
+			if peers.Known(address) {
+				t.Errorf("Node on chain %q with genesis %x was accepted", identity.chainID, identity.genesisHash)
+			}
+		}
+		hello := testIdentity().Hello(nil)
+		hello.Version = ProtocolVersion + 1
+		if testIdentity().compatible(hello) == nil {
+			t.Error("Node with another protocol version was considered compatible")
+		}
+	})
+	t.Run("Test refusing to connect to itself", func(t *testing.T) {
+		identity := testIdentity()
+		address, _ := servePeers(t, NewPeers(identity, NewHTTPTransport(), 8, 8))
+		peers := NewPeers(identity, NewHTTPTransport(), 8, 8)
+		peers.Add(address)
+		if peers.Known(address) {
+			t.Error("Node connected to itself")
+		}
+	})
+	t.Run("Test rejecting forged handshake confirmation", func(t *testing.T) {
+		server := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		address, events := servePeers(t, server)
+		client := NewNodeClient(NewHTTPTransport(), address)
+		identity := testIdentity()
+		challenge, _ := newChallenge()
+		var response HandshakeResponse
+		if _, err := client.postJSON("/handshake/", identity.Hello(challenge), &response); err != nil {
+			t.Fatal(err)
+		}
+
+		forged := HandshakeConfirmation{response.Hello.Challenge, testIdentity().sign(response.Hello.Challenge)}
+		if _, err := client.postJSON("/handshake/confirm/", forged, nil); err == nil {
+			t.Error("Forged handshake confirmation was accepted")
+		}
+		if len(events) != 0 {
+			t.Error("Node with forged identity was accepted as peer")
+		}
+		scores := server.Misbehavior().Scores()
+		if len(scores) != 1 || scores[0].Score != penaltyInvalidSignature {
+			t.Errorf("Forged confirmation was not penalized: %+v", scores)
+		}
+	})
+	t.Run("Test verifying address of inbound peer", func(t *testing.T) {
+		server := testIdentity()
+		address, _ := servePeers(t, NewPeers(server, NewHTTPTransport(), 8, 8))
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		peers.AcceptInbound(address, keys.NewKeyPair().PublicKey)
+		if peers.Known(address) {
+			t.Error("Peer claiming address of another node was accepted")
+		}
+		peers.AcceptInbound(address, server.PublicKey())
+		if !peers.Known(address) {
+			t.Error("Peer at its own address was not accepted")
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork connects nodes in the same process without sockets. Requests are handed directly to the
+// handler of the node they are addressed to, after an optional latency. Requests can also be lost at
+// random and nodes can be partitioned from each other. Whether a request is lost is decided by hashing the
+// seed, the source and destination of the request and the number of requests sent over that link before
+// it. Whether the n-th request on a link is lost is the same in every run with the same seed, no matter
+// how the goroutines sending requests over other links are scheduled.
+type MemoryNetwork struct {
+	sync.Mutex
+	handlers map[string]http.Handler
+	// partitions maps the addresses of partitioned nodes to their partition. Nodes without a partition can
+	// reach each other.
+	partitions map[string]int
+	latency    time.Duration
+	lossRate   float64
+	seed       int64
+	// sent holds the number of requests sent over each link by source and destination
+	sent map[[2]string]uint64
+}
+
+// NewMemoryNetwork returns an in-memory network which decides random losses using the given seed
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		handlers:   make(map[string]http.Handler),
+		partitions: make(map[string]int),
+		seed:       seed,
+		sent:       make(map[[2]string]uint64),
+	}
+}
+
+// SetLatency sets how long every request takes to arrive
+func (n *MemoryNetwork) SetLatency(latency time.Duration) {
+	n.Lock()
+	defer n.Unlock()
+	n.latency = latency
+}
+
+// SetLossRate sets the probability of a request getting lost
+func (n *MemoryNetwork) SetLossRate(lossRate float64) {
+	n.Lock()
+	defer n.Unlock()
+	n.lossRate = lossRate
+}
+
+// Partition splits the network so that nodes can only reach the nodes in the same group. Nodes which are
+// not in any group form a group of their own.
+func (n *MemoryNetwork) Partition(groups ...[]string) {
+	n.Lock()
+	defer n.Unlock()
+	n.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			n.partitions[address] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (n *MemoryNetwork) Heal() {
+	n.Partition()
+}
+
+// Transport returns the transport of the node at the given address
+func (n *MemoryNetwork) Transport(address string) Transport {
+	return &memoryTransport{network: n, address: address}
+}
+
+// route returns the handler of the node at the destination address if the request reaches it
+func (n *MemoryNetwork) route(source string, destination string) (http.Handler, time.Duration, error) {
+	n.Lock()
+	defer n.Unlock()
+	handler, ok := n.handlers[destination]
+	if !ok {
+		return nil, 0, fmt.Errorf("No node listening at %s", destination)
+	}
+	if n.partitions[source] != n.partitions[destination] {
+		return nil, 0, fmt.Errorf("%s is unreachable from %s", destination, source)
+	}
+	if n.lost(source, destination) {
+		return nil, 0, fmt.Errorf("Request from %s to %s was lost", source, destination)
+	}
+	return handler, n.latency, nil
+}
+
+// lost decides whether the next request from the source to the destination is lost
+func (n *MemoryNetwork) lost(source string, destination string) bool {
+	link := [2]string{source, destination}
+	sequence := n.sent[link]
+	n.sent[link]++
+	if n.lossRate <= 0 {
+		return false
+	}
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, n.seed)
+	binary.Write(hash, binary.BigEndian, sequence)
+	// The addresses are length prefixed so that different pairs of addresses never hash the same
+	for _, address := range link {
+		binary.Write(hash, binary.BigEndian, uint32(len(address)))
+		hash.Write([]byte(address))
+	}
+	// The top 53 bits of the hash make a uniformly distributed float in [0, 1)
+	sample := float64(binary.BigEndian.Uint64(hash.Sum(nil))>>11) / (1 << 53)
+	return sample < n.lossRate
+}
+
+// memoryTransport carries the requests of a single node over an in-memory network
+type memoryTransport struct {
+	network *MemoryNetwork
+	address string
+}
+
+// Do hands the request to the handler of the node it is addressed to
+func (t *memoryTransport) Do(request *http.Request) (*http.Response, error) {
+	handler, latency, err := t.network.route(t.address, request.URL.Host)
+	if err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+	time.Sleep(latency)
+	request.RemoteAddr = t.address
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Result(), nil
+}
+
+// Serve registers the handler of the node at the given address. Unlike serving over HTTP, it returns
+// immediately.
+func (t *memoryTransport) Serve(address string, handler http.Handler) error {
+	t.network.Lock()
+	defer t.network.Unlock()
+	if _, ok := t.network.handlers[address]; ok {
+		return errors.New("Address is already in use")
+	}
+	t.network.handlers[address] = handler
+	return nil
+}
+
+// Secure returns false since requests never leave the process
+func (t *memoryTransport) Secure() bool {
+	return false
+}
+
+// Mutual returns false since there are no certificates to present
+func (t *memoryTransport) Mutual() bool {
+	return false
+}
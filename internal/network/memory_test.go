@@ -0,0 +1,153 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// startMemoryNode starts a node which does not mine on the in-memory network
+func startMemoryNode(t *testing.T, network *MemoryNetwork, address string) *Node {
+	chain := blockchain.NewBlockchain(keys.NewKeyPair(), blockchain.WithGenesis(testGenesis()))
+	identity := NewIdentity(keys.NewKeyPair(), "test", testGenesis().Hash, address)
+	transport := network.Transport(address)
+	node := newNode(chain, NewPeers(identity, transport, 20, 20))
+	if err := transport.Serve(address, node.api.Handler()); err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// pollAll lets every node poll the tips of its peers once
+func pollAll(nodes []*Node) {
+	for _, node := range nodes {
+		NewTipPoller(node.chain, node.peers, node.syncer).Poll()
+		node.updateCache(*node.chain.LastBlock())
+	}
+}
+
+// converged tells whether all nodes have the given tip
+func converged(nodes []*Node, tip *blockchain.Block) bool {
+	for _, node := range nodes {
+		if !bytes.Equal(node.chain.LastBlock().Hash, tip.Hash) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryNetwork(t *testing.T) {
+	t.Run("Test injecting latency, loss and partitions", func(t *testing.T) {
+		network := NewMemoryNetwork(1)
+		startMemoryNode(t, network, "a:1")
+		startMemoryNode(t, network, "b:1")
+		client := NewNodeClient(network.Transport("a:1"), "b:1")
+		if _, err := client.GetTip(); err != nil {
+			t.Fatalf("Request over in-memory network failed: %v", err)
+		}
+
+		network.Partition([]string{"a:1"})
+		if _, err := client.GetTip(); err == nil {
+			t.Error("Request crossed partition")
+		}
+		network.Heal()
+		network.SetLossRate(1)
+		if _, err := client.GetTip(); err == nil {
+			t.Error("Request was not lost")
+		}
+		network.SetLossRate(0)
+		network.SetLatency(20 * time.Millisecond)
+		start := time.Now()
+		if _, err := client.GetTip(); err != nil || time.Since(start) < 20*time.Millisecond {
+			t.Errorf("Request did not arrive with latency: %v", err)
+		}
+	})
+	t.Run("Test losing same requests on a link regardless of other links", func(t *testing.T) {
+		losses := func(interleaved bool) []bool {
+			network := NewMemoryNetwork(7)
+			network.SetLossRate(0.5)
+			pattern := []bool{}
+			for i := 0; i < 50; i++ {
+				if interleaved && i%3 == 0 {
+					network.lost("c:1", "b:1")
+				}
+				pattern = append(pattern, network.lost("a:1", "b:1"))
+			}
+			return pattern
+		}
+		first, second := losses(false), losses(true)
+		lost := 0
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("Request %d on link was lost in only one of the runs", i)
+			}
+			if first[i] {
+				lost++
+			}
+		}
+		if lost == 0 || lost == len(first) {
+			t.Errorf("Expected some but not all requests to be lost but %d of %d were", lost, len(first))
+		}
+	})
+	t.Run("Test converging 20 nodes after partition", func(t *testing.T) {
+		const count = 20
+		network := NewMemoryNetwork(1)
+		nodes := make([]*Node, count)
+		addresses := make([]string, count)
+		for i := range nodes {
+			addresses[i] = fmt.Sprintf("node%d:8000", i)
+			nodes[i] = startMemoryNode(t, network, addresses[i])
+		}
+		// Every node connects to the next node and to a node further away, and accepts the nodes which
+		// connect to it as inbound peers
+		for i, node := range nodes {
+			node.peers.Add(addresses[(i+1)%count])
+			node.peers.Add(addresses[(i+7)%count])
+		}
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			connected := 0
+			for _, node := range nodes {
+				if len(node.peers.Addresses()) == 4 {
+					connected++
+				}
+			}
+			if connected == count {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Only %d nodes connected to all of their peers", connected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		network.SetLatency(time.Millisecond)
+		network.SetLossRate(0.05)
+		network.Partition(addresses[:count/2], addresses[count/2:])
+		for i := 0; i < 2; i++ {
+			nodes[0].chain.MineBlock()
+		}
+		for i := 0; i < 4; i++ {
+			nodes[count/2].chain.MineBlock()
+		}
+		heaviest := nodes[count/2].chain.LastBlock()
+		pollAll(nodes)
+		pollAll(nodes)
+		for _, node := range nodes[:count/2] {
+			if bytes.Equal(node.chain.LastBlock().Hash, heaviest.Hash) {
+				t.Fatal("Block crossed partition")
+			}
+		}
+
+		network.Heal()
+		for round := 0; !converged(nodes, heaviest); round++ {
+			if round == 10 {
+				t.Fatal("Nodes did not converge after partition healed")
+			}
+			pollAll(nodes)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// banThreshold is the misbehavior score at which a host is banned
+	banThreshold = 100
+	// DefaultBanDuration is how long a host is banned for by default
+	DefaultBanDuration = 24 * time.Hour
+
+	penaltyMalformedMessage   = 10
+	penaltyInvalidSignature   = 50
+	penaltyInvalidProofOfWork = 100
+)
+
+// misbehavior is the misbehavior recorded for a single host
+type misbehavior struct {
+	score       int
+	reason      string
+	bannedUntil time.Time
+}
+
+// MisbehaviorScore describes the misbehavior of a host
+type MisbehaviorScore struct {
+	Host        string    `json:"host"`
+	Score       int       `json:"score"`
+	Reason      string    `json:"reason"`
+	Banned      bool      `json:"banned"`
+	BannedUntil time.Time `json:"bannedUntil"`
+}
+
+// Misbehavior scores hosts which send invalid messages. Every invalid message adds a penalty depending on
+// how costly it is to produce, and hosts whose score reaches the threshold are banned for a while. Hosts
+// are identified by their IP address instead of their advertised address, since the latter is chosen by
+// the host itself.
+type Misbehavior struct {
+	sync.Mutex
+	hosts       map[string]*misbehavior
+	banDuration time.Duration
+}
+
+// NewMisbehavior returns a misbehavior tracker which bans hosts for the given duration
+func NewMisbehavior(banDuration time.Duration) *Misbehavior {
+	return &Misbehavior{
+		hosts:       make(map[string]*misbehavior),
+		banDuration: banDuration,
+	}
+}
+
+// hostOf returns the host part of an address, or the address itself if it has no port
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// SetBanDuration changes how long hosts are banned for
+func (m *Misbehavior) SetBanDuration(banDuration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.banDuration = banDuration
+}
+
+// Penalize adds the penalty to the score of the host and bans the host if its score reaches the threshold
+func (m *Misbehavior) Penalize(host string, penalty int, reason string) {
+	m.Lock()
+	defer m.Unlock()
+	entry, ok := m.hosts[host]
+	if !ok {
+		entry = &misbehavior{}
+		m.hosts[host] = entry
+	}
+	entry.score += penalty
+	entry.reason = reason
+	log.Printf("Penalized %s by %d to %d: %s\n", host, penalty, entry.score, reason)
+	if entry.score >= banThreshold && !entry.bannedUntil.After(time.Now()) {
+		entry.bannedUntil = time.Now().Add(m.banDuration)
+		log.Printf("Banned %s until %s\n", host, entry.bannedUntil.Format(time.RFC3339))
+	}
+}
+
+// Banned tells whether the host is currently banned. The score of a host is cleared once its ban expires.
+func (m *Misbehavior) Banned(host string) bool {
+	m.Lock()
+	defer m.Unlock()
+	entry, ok := m.hosts[host]
+	if !ok || entry.bannedUntil.IsZero() {
+		return false
+	}
+	if entry.bannedUntil.After(time.Now()) {
+		return true
+	}
+	delete(m.hosts, host)
+	return false
+}
+
+// Scores returns the misbehavior scores of all hosts, the highest score first
+func (m *Misbehavior) Scores() []MisbehaviorScore {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	scores := make([]MisbehaviorScore, 0, len(m.hosts))
+	for host, entry := range m.hosts {
+		scores = append(scores, MisbehaviorScore{
+			Host:        host,
+			Score:       entry.score,
+			Reason:      entry.reason,
+			Banned:      entry.bannedUntil.After(now),
+			BannedUntil: entry.bannedUntil,
+		})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores
+}
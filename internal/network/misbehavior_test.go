@@ -0,0 +1,142 @@
+package network
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestMisbehavior(t *testing.T) {
+	t.Run("Test banning host above threshold", func(t *testing.T) {
+		misbehavior := NewMisbehavior(time.Hour)
+		misbehavior.Penalize("10.0.0.1", penaltyInvalidSignature, "Invalid signature")
+		misbehavior.Penalize("10.0.0.2", penaltyMalformedMessage, "Malformed message")
+		if misbehavior.Banned("10.0.0.1") {
+			t.Error("Host was banned below threshold")
+		}
+		misbehavior.Penalize("10.0.0.1", penaltyInvalidSignature, "Invalid signature")
+		if !misbehavior.Banned("10.0.0.1") {
+			t.Error("Host was not banned above threshold")
+		}
+		scores := misbehavior.Scores()
+		if len(scores) != 2 || scores[0].Host != "10.0.0.1" || !scores[0].Banned || scores[1].Banned {
+			t.Errorf("Unexpected scores: %+v", scores)
+		}
+	})
+	t.Run("Test lifting ban after ban duration", func(t *testing.T) {
+		misbehavior := NewMisbehavior(time.Millisecond)
+		misbehavior.Penalize("10.0.0.1", penaltyInvalidProofOfWork, "Invalid proof-of-work")
+		time.Sleep(5 * time.Millisecond)
+		if misbehavior.Banned("10.0.0.1") {
+			t.Error("Ban was not lifted")
+		}
+		if len(misbehavior.Scores()) != 0 {
+			t.Error("Score was not cleared after ban")
+		}
+	})
+	t.Run("Test penalizing invalid messages sent to API", func(t *testing.T) {
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		peers.insert("127.0.0.1:1", true)
+		address, _ := servePeers(t, peers)
+		client := NewNodeClient(NewHTTPTransport(), address)
+
+		response, err := http.Post(client.apiUrl("/handshake/"), "application/json", strings.NewReader("{"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		sender := keys.NewKeyPair()
+		transaction := blockchain.NewTransaction(sender.PublicKey, keys.NewKeyPair().PublicKey, 1, 1, 1)
+		transaction.Sign(sender.PrivateKey)
+		transaction.Amount = 2
+		if client.SendTransaction(*transaction) == nil {
+			t.Error("Transaction with invalid signature was accepted")
+		}
+		scores := peers.Misbehavior().Scores()
+		if len(scores) != 1 || scores[0].Score != penaltyMalformedMessage+penaltyInvalidSignature {
+			t.Fatalf("Unexpected scores: %+v", scores)
+		}
+
+		// The hash is computed from the header when the block is decoded, so the block is given a difficulty
+		// its hash does not meet
+		block := *mineTestChain(1).LastBlock()
+		block.Difficulty = math.MaxUint64
+		if client.SendBlock(block) == nil {
+			t.Error("Block with invalid proof-of-work was accepted")
+		}
+		if !peers.Misbehavior().Banned("127.0.0.1") {
+			t.Error("Host sending invalid proof-of-work was not banned")
+		}
+		if peers.Known("127.0.0.1:1") {
+			t.Error("Peer on banned host was not disconnected")
+		}
+		if _, err := client.GetTip(); err == nil {
+			t.Error("Banned host was served")
+		}
+	})
+	t.Run("Test penalizing blocks with wrong difficulty", func(t *testing.T) {
+		// Blocks on top of this genesis block need a difficulty of 2, so a block with a difficulty of 1 has a
+		// valid proof-of-work without any work done
+		genesis := testGenesis()
+		genesis.Difficulty = 2
+		genesis.Hash = genesis.ComputeHash()
+		chain := blockchain.NewBlockchain(keys.NewKeyPair(), blockchain.WithGenesis(genesis))
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		address, _ := serveNode(t, chain, peers)
+		client := NewNodeClient(NewHTTPTransport(), address)
+
+		coinbase := blockchain.CoinbaseTransactionTo(keys.NewKeyPair().PublicKey, 0)
+		block := blockchain.NewBlock(1, genesis.Hash, 1, []blockchain.Transaction{coinbase}, 0)
+		if err := client.SendBlock(*block); err == nil {
+			t.Error("Block with wrong difficulty was accepted")
+		}
+		scores := peers.Misbehavior().Scores()
+		if len(scores) != 1 || scores[0].Score != penaltyInvalidProofOfWork {
+			t.Errorf("Unexpected scores: %+v", scores)
+		}
+	})
+	t.Run("Test penalizing relayed blocks rejected by blockchain", func(t *testing.T) {
+		chain := mineTestChain(1)
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+
+		orphan := blockchain.NewBlock(5, []byte("unknown"), 1, nil, 0)
+		handleBlock(chain, peers, NewBlock{Block: *orphan, Source: "10.0.0.1:8000"})
+		if len(peers.Misbehavior().Scores()) != 0 {
+			t.Error("Peer sending block with unknown parent was penalized")
+		}
+
+		tip := chain.LastBlock()
+		invalid := blockchain.NewBlock(tip.Number+1, tip.Hash, 1, []blockchain.Transaction{*blockchain.NewTransaction(nil, nil, 1, 0, 1)}, 0)
+		handleBlock(chain, peers, NewBlock{Block: *invalid, Source: "10.0.0.1:8000"})
+		scores := peers.Misbehavior().Scores()
+		if len(scores) != 1 || scores[0].Host != "10.0.0.1" {
+			t.Errorf("Unexpected scores: %+v", scores)
+		}
+		if chain.LastBlock() != tip {
+			t.Error("Invalid block was added")
+		}
+	})
+	t.Run("Test not penalizing relayed blocks which fail to be stored", func(t *testing.T) {
+		store, err := blockchain.OpenFileStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain := blockchain.NewBlockchain(keys.NewKeyPair(), blockchain.WithGenesis(testGenesis()), blockchain.WithStore(store))
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		// Appending to a closed store fails like a full disk would
+		store.Close()
+
+		handleBlock(chain, peers, NewBlock{Block: *mineTestChain(1).LastBlock(), Source: "10.0.0.1:8000"})
+		if chain.LastBlock().Number != 0 {
+			t.Fatal("Block was added without storing it")
+		}
+		if len(peers.Misbehavior().Scores()) != 0 {
+			t.Error("Peer was penalized for a local storage failure")
+		}
+	})
+}
@@ -1,7 +1,10 @@
 package network
 
 import (
+	"errors"
 	"log"
+	"path/filepath"
+	"time"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
 	"github.com/coocos/cryptocurrency/internal/config"
@@ -10,52 +13,104 @@ import (
 
 // Node represents the node running the blockchain
 type Node struct {
-	chain *blockchain.Blockchain
-	api   *Api
-	peers *Peers
+	chain  *blockchain.Blockchain
+	api    *Api
+	peers  *Peers
+	syncer *Syncer
 }
 
 // NewNode returns a new node which mines blocks using the given key pair
 func NewNode(keyPair *keys.KeyPair) *Node {
-	chain := blockchain.NewBlockchain(keyPair)
-	peers := &Peers{}
+	store, err := blockchain.OpenFileStore(config.DataDir())
+	if err != nil {
+		log.Fatalf("Failed to open block store: %v\n", err)
+	}
+	options := []blockchain.Option{
+		blockchain.WithTargetBlockInterval(config.TargetBlockInterval()),
+		blockchain.WithTemplateRefreshInterval(config.TemplateRefreshInterval()),
+		blockchain.WithMempoolLimits(config.MempoolSize(), config.MempoolMaxAge()),
+		blockchain.WithReplacementFeeBump(config.ReplacementFeeBump()),
+		blockchain.WithStore(store),
+	}
+	if config.Archival() {
+		log.Println("Running in archival mode, past account states are kept")
+		options = append(options, blockchain.WithArchive(config.CheckpointInterval()))
+	}
+	chain := blockchain.NewBlockchain(keyPair, options...)
+	identityKey, err := keys.LoadOrCreateKeyPair(filepath.Join(config.DataDir(), "identity.key"))
+	if err != nil {
+		log.Fatalf("Failed to load node identity: %v\n", err)
+	}
+	identity := NewIdentity(identityKey, config.ChainID(), chain.Genesis().Hash, config.AdvertisedHost())
+	log.Printf("Node identity is %s\n", identityKey.PublicKeyBase64)
+	var transport Transport = NewHTTPTransport()
+	if config.Insecure() {
+		log.Println("Running in insecure mode, communication with other nodes is unencrypted")
+	} else if transport, err = NewTLSTransport(identityKey, config.MutualTLS()); err != nil {
+		log.Fatalf("Failed to enable TLS: %v\n", err)
+	}
+	peers := NewPeers(identity, transport, config.MaxInboundPeers(), config.MaxOutboundPeers())
+	peers.Misbehavior().SetBanDuration(config.BanDuration())
+	return newNode(chain, peers)
+}
+
+// newNode returns a node which runs the blockchain and communicates with the peers
+func newNode(chain *blockchain.Blockchain, peers *Peers) *Node {
 	events := eventBus(chain, peers)
-	api := NewApi(events)
-	return &Node{
-		chain: chain,
-		api:   api,
-		peers: peers,
+	api := NewApi(chain, peers, events)
+	node := &Node{
+		chain:  chain,
+		api:    api,
+		peers:  peers,
+		syncer: NewSyncer(chain, peers),
 	}
+	node.updateCache(*chain.LastBlock())
+	return node
 }
 
 // Start starts the node
 func (n *Node) Start() {
 	n.serve()
+	// Peers from the address book let the node rejoin the network even without a seed host
+	if err := n.peers.Book().Load(addressBookPath()); err != nil {
+		log.Printf("Failed to load address book: %v\n", err)
+	}
+	n.peers.Reconnect(config.AdvertisedHost())
 	if seedHost, ok := config.SeedHost(); ok {
 		log.Println("Syncing blockchain via", seedHost)
 		n.peers.Add(seedHost)
-		blocks, err := n.peers.GetBlocks(seedHost)
-		if err != nil {
+		if !n.peers.Known(seedHost) {
+			log.Println("Failed to connect to seed node")
+		} else if err := n.syncer.Sync(seedHost); err != nil {
 			log.Println("Failed to sync blockchain using seed node:", err)
-		} else {
-			for _, block := range blocks {
-				n.chain.SubmitExternalBlock(&block)
-			}
 		}
 	}
+	poller := NewTipPoller(n.chain, n.peers, n.syncer)
+	poller.Poll()
+	n.updateCache(*n.chain.LastBlock())
+	go poller.Run(config.TipPollInterval())
+	go n.maintainPeers(config.PeerExchangeInterval())
 	n.mine()
 }
 
+func addressBookPath() string {
+	return filepath.Join(config.DataDir(), "peers.json")
+}
+
 func eventBus(chain *blockchain.Blockchain, peers *Peers) chan<- interface{} {
 	events := make(chan interface{})
 	go func() {
 		for event := range events {
 			switch e := event.(type) {
 			case NewBlock:
-				chain.SubmitExternalBlock(&e.Block)
+				log.Printf("Received block %d from %s\n", e.Block.Number, e.Source)
+				handleBlock(chain, peers, e)
+			case NewTransaction:
+				log.Printf("Relaying new transaction from %s: %v\n", e.Source, e.Transaction)
+				go peers.BroadcastTransaction(e.Transaction)
 			case NewPeer:
-				log.Println("Node @", e.Address, "sent greeting")
-				peers.Add(e.Address)
+				log.Println("Node @", e.Address, "completed handshake")
+				go peers.AcceptInbound(e.Address, e.PublicKey)
 			default:
 				log.Fatalf("Received an unknown event: %v\n", event)
 
@@ -65,6 +120,20 @@ func eventBus(chain *blockchain.Blockchain, peers *Peers) chan<- interface{} {
 	return events
 }
 
+// handleBlock adds a block received from a peer to the blockchain and penalizes the peer if the block is
+// invalid. Blocks with unknown parents are not penalized, since the peer may just be ahead of the node,
+// and neither are blocks which could not be added because of a local failure.
+func handleBlock(chain *blockchain.Blockchain, peers *Peers, block NewBlock) {
+	err := chain.AddBlock(&block.Block)
+	if err == nil || err == blockchain.ErrOrphanBlock {
+		return
+	}
+	log.Printf("Rejected block %v from %s: %v\n", block.Block, block.Source, err)
+	if err == blockchain.ErrWrongDifficulty || errors.Is(err, blockchain.ErrInvalidBlock) {
+		peers.Penalize(hostOf(block.Source), penaltyFor(err), err.Error())
+	}
+}
+
 func (n *Node) serve() {
 	go func() {
 		if err := n.api.Serve(); err != nil {
@@ -73,10 +142,39 @@ func (n *Node) serve() {
 	}()
 }
 
+// maintainPeers periodically replaces evicted peers from the address book, discovers new peers via the
+// connected peers and persists the address book
+func (n *Node) maintainPeers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n.peers.Reconnect(config.AdvertisedHost())
+		n.peers.Exchange(config.AdvertisedHost())
+		if err := n.peers.Book().Save(addressBookPath()); err != nil {
+			log.Printf("Failed to save address book: %v\n", err)
+		}
+	}
+}
+
 func (n *Node) mine() {
 	for {
 		block := n.chain.MineBlock()
-		n.api.updateCache(block)
+		n.updateCache(block)
 		n.peers.BroadcastBlock(block)
 	}
 }
+
+// updateCache brings the API cache in line with the main chain ending at the given block
+func (n *Node) updateCache(block blockchain.Block) {
+	// Walk back from the new block until reaching a block the cache already has
+	blocks := []blockchain.Block{block}
+	for first := block; first.Number > 0 && !n.api.cache.HasBlock(first.Number-1, first.PreviousHash); {
+		parent, exists := n.chain.BlockByHash(first.PreviousHash)
+		if !exists {
+			log.Fatalf("Main chain is missing parent of %v\n", first)
+		}
+		first = *parent
+		blocks = append([]blockchain.Block{first}, blocks...)
+	}
+	n.api.updateCache(blocks)
+}
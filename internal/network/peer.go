@@ -1,67 +1,396 @@
 package network
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/coocos/cryptocurrency/internal/blockchain"
 )
 
-// Peers contains all the peers a node knows about
+const (
+	// maxPeerFailures is the number of consecutive failed requests after which a peer is evicted
+	maxPeerFailures = 3
+	// latencySmoothing is the weight of the previous latency when a new latency sample is averaged in
+	latencySmoothing = 0.75
+)
+
+// peer is a connected peer node along with what this node has observed about it
+type peer struct {
+	// outbound tells whether this node connected to the peer or the peer connected to this node
+	outbound bool
+	// publicKey is the identity the peer proved it owns during the handshake
+	publicKey ed25519.PublicKey
+	lastSeen  time.Time
+	failures  int
+	latency   time.Duration
+	height    int
+}
+
+// PeerInfo describes a connected peer node
+type PeerInfo struct {
+	Address   string            `json:"address"`
+	PublicKey ed25519.PublicKey `json:"publicKey"`
+	Outbound  bool              `json:"outbound"`
+	LastSeen  time.Time         `json:"lastSeen"`
+	Failures  int               `json:"failures"`
+	Latency   string            `json:"latency"`
+	Height    int               `json:"height"`
+}
+
+// Peers contains all the peers a node knows about. The number of peers which connected to this node and
+// the number of peers this node connected to are limited separately, so that every node keeps room for
+// connecting to peers of its choice. Peers which stop responding are evicted, but their addresses are kept
+// in the address book so that they can be retried later. Hosts which misbehave are banned.
 type Peers struct {
 	sync.RWMutex
-	hosts map[string]bool
+	identity    *Identity
+	transport   Transport
+	hosts       map[string]*peer
+	book        *AddressBook
+	misbehavior *Misbehavior
+	maxInbound  int
+	maxOutbound int
+}
+
+// NewPeers returns an empty set of peers with the given limits for the node with the given identity. The
+// peers are contacted using the given transport.
+func NewPeers(identity *Identity, transport Transport, maxInbound int, maxOutbound int) *Peers {
+	return &Peers{
+		identity:    identity,
+		transport:   transport,
+		hosts:       make(map[string]*peer),
+		book:        NewAddressBook(),
+		misbehavior: NewMisbehavior(DefaultBanDuration),
+		maxInbound:  maxInbound,
+		maxOutbound: maxOutbound,
+	}
+}
+
+// count returns the number of inbound or outbound peers
+func (p *Peers) count(outbound bool) int {
+	count := 0
+	for _, peer := range p.hosts {
+		if peer.outbound == outbound {
+			count++
+		}
+	}
+	return count
+}
+
+// insert adds a peer unless it is already known or the limit for its direction has been reached
+func (p *Peers) insert(address string, outbound bool) bool {
+	if _, ok := p.hosts[address]; ok {
+		return false
+	}
+	limit := p.maxInbound
+	if outbound {
+		limit = p.maxOutbound
+	}
+	if p.count(outbound) >= limit {
+		return false
+	}
+	p.hosts[address] = &peer{outbound: outbound, lastSeen: time.Now()}
+	p.book.Seen(address)
+	return true
 }
 
-// Add adds a new peer node and shakes hands with it
+// hasIdentity tells whether a peer with the given identity is connected
+func (p *Peers) hasIdentity(publicKey ed25519.PublicKey) bool {
+	for _, peer := range p.hosts {
+		if bytes.Equal(peer.publicKey, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add connects to a new outbound peer node by shaking hands with it
 func (p *Peers) Add(address string) {
+	if p.Known(address) {
+		return
+	}
+	if p.misbehavior.Banned(hostOf(address)) {
+		log.Printf("Not connecting to %s since it is banned\n", address)
+		return
+	}
+	if !p.NeedsOutbound() {
+		log.Printf("Not connecting to %s since outbound peer limit has been reached\n", address)
+		return
+	}
+
+	p.book.Add(address)
+	hello, err := p.client(address).Handshake(p.identity)
+	if err != nil {
+		log.Printf("Handshake with peer failed, dropping it: %v\n", err)
+		p.book.Failed(address)
+		return
+	}
 	p.Lock()
 	defer p.Unlock()
+	if p.hasIdentity(hello.PublicKey) {
+		log.Printf("Not connecting to %s since a peer with its identity is already connected\n", address)
+		return
+	}
+	if p.insert(address, true) {
+		p.hosts[address].publicKey = hello.PublicKey
+	}
+}
 
-	if _, ok := p.hosts[address]; ok {
+// AcceptInbound adds a peer node which completed a handshake with this node. The address the peer claims
+// is only trusted if the node at the address has the same identity, so that peers can not make this node
+// send messages to arbitrary hosts.
+func (p *Peers) AcceptInbound(address string, publicKey ed25519.PublicKey) {
+	if address == p.identity.address {
+		return
+	}
+	// The certificate of the node at the address needs to be for the identity too
+	client := p.client(address)
+	client.publicKey = publicKey
+	hello, err := client.GetIdentity()
+	if err != nil {
+		log.Printf("Failed to verify identity of %s: %v\n", address, err)
 		return
 	}
+	if !bytes.Equal(hello.PublicKey, publicKey) {
+		log.Printf("Ignoring handshake from %s since the node at the address has another identity\n", address)
+		return
+	}
+	p.AddInbound(address, publicKey)
+}
 
-	client := NodeClient{address}
-	if err := client.Greet(); err != nil {
-		log.Printf("Peer failed to respond, dropping it: %v\n", err)
+// AddInbound adds a peer node with the given identity which completed a handshake with this node
+func (p *Peers) AddInbound(address string, publicKey ed25519.PublicKey) {
+	p.Lock()
+	defer p.Unlock()
+
+	if peer, ok := p.hosts[address]; ok && bytes.Equal(peer.publicKey, publicKey) {
+		peer.lastSeen = time.Now()
 		return
 	}
-	if p.hosts == nil {
-		p.hosts = make(map[string]bool)
+	if p.hasIdentity(publicKey) {
+		log.Printf("Ignoring handshake from %s since a peer with its identity is already connected\n", address)
+		return
 	}
-	p.hosts[address] = true
+	if !p.insert(address, false) {
+		log.Printf("Ignoring handshake from %s since inbound peer limit has been reached\n", address)
+		return
+	}
+	p.hosts[address].publicKey = publicKey
 }
 
-// GetBlocks gets all blocks from peer node
-func (p *Peers) GetBlocks(address string) ([]blockchain.Block, error) {
+// NeedsOutbound tells whether there is room for more outbound peers
+func (p *Peers) NeedsOutbound() bool {
 	p.RLock()
 	defer p.RUnlock()
-	client := NodeClient{address}
-	blocks, err := client.GetBlocks()
+	return p.count(true) < p.maxOutbound
+}
+
+// Exchange asks every known peer for its peers and connects to the ones this node does not know yet until
+// the outbound limit is reached. The own address of the node is skipped.
+func (p *Peers) Exchange(self string) {
+	for _, address := range p.Addresses() {
+		if !p.NeedsOutbound() {
+			return
+		}
+		var addresses []string
+		err := p.contact(address, func(client *NodeClient) (err error) {
+			addresses, err = client.GetPeers()
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to exchange peers with %s: %v\n", address, err)
+			continue
+		}
+		for _, discovered := range addresses {
+			if discovered == self || !p.NeedsOutbound() {
+				continue
+			}
+			if p.Known(discovered) || !p.book.Add(discovered) {
+				continue
+			}
+			log.Printf("Discovered peer %s via %s\n", discovered, address)
+			p.Add(discovered)
+		}
+	}
+}
+
+// Reconnect connects to addresses in the address book whose backoff has passed until the outbound limit
+// is reached. The own address of the node is skipped.
+func (p *Peers) Reconnect(self string) {
+	for _, address := range p.book.Ready(time.Now()) {
+		if !p.NeedsOutbound() {
+			return
+		}
+		if address == self || p.Known(address) {
+			continue
+		}
+		p.Add(address)
+	}
+}
+
+// client returns a client for the node at the given address
+func (p *Peers) client(address string) *NodeClient {
+	return NewNodeClient(p.transport, address)
+}
+
+// Known tells whether the peer with the given address is connected
+func (p *Peers) Known(address string) bool {
+	p.RLock()
+	defer p.RUnlock()
+	_, ok := p.hosts[address]
+	return ok
+}
+
+// Identity returns the identity of the node the peers are connected to
+func (p *Peers) Identity() *Identity {
+	return p.identity
+}
+
+// Book returns the address book of the peers
+func (p *Peers) Book() *AddressBook {
+	return p.book
+}
+
+// Misbehavior returns the misbehavior scores of the hosts which have sent messages to the node
+func (p *Peers) Misbehavior() *Misbehavior {
+	return p.misbehavior
+}
+
+// Penalize records that the host sent an invalid message. If the host ends up banned, the peers running on
+// it are disconnected.
+func (p *Peers) Penalize(host string, penalty int, reason string) {
+	p.misbehavior.Penalize(host, penalty, reason)
+	if !p.misbehavior.Banned(host) {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	for address := range p.hosts {
+		if hostOf(address) == host {
+			log.Printf("Disconnecting banned peer %s\n", address)
+			delete(p.hosts, address)
+		}
+	}
+}
+
+// contact calls the peer and records whether it responded and how long it took. A peer which fails to
+// respond too many times in a row is evicted and its address is retried later with a backoff.
+func (p *Peers) contact(address string, call func(*NodeClient) error) error {
+	client := p.client(address)
+	p.RLock()
+	if peer, ok := p.hosts[address]; ok {
+		client.publicKey = peer.publicKey
+	}
+	p.RUnlock()
+	start := time.Now()
+	err := call(client)
+	latency := time.Since(start)
+
+	p.Lock()
+	defer p.Unlock()
+	peer, ok := p.hosts[address]
+	if !ok {
+		return err
+	}
 	if err != nil {
-		return []blockchain.Block{}, err
+		peer.failures++
+		if peer.failures >= maxPeerFailures {
+			log.Printf("Evicting peer %s after %d failed requests\n", address, peer.failures)
+			delete(p.hosts, address)
+			p.book.Failed(address)
+		}
+		return err
+	}
+	peer.failures = 0
+	peer.lastSeen = time.Now()
+	if peer.latency == 0 {
+		peer.latency = latency
+	} else {
+		peer.latency = time.Duration(latencySmoothing*float64(peer.latency) + (1-latencySmoothing)*float64(latency))
 	}
-	return blocks, nil
+	p.book.Seen(address)
+	return nil
 }
 
-// BroadcastBlock sends block to all known peer ndoes
-func (p *Peers) BroadcastBlock(block blockchain.Block) {
+// SetHeight records the height of the main chain the peer advertised
+func (p *Peers) SetHeight(address string, height int) {
+	p.Lock()
+	defer p.Unlock()
+	if peer, ok := p.hosts[address]; ok {
+		peer.height = height
+	}
+}
+
+// Info returns what this node has observed about each connected peer
+func (p *Peers) Info() []PeerInfo {
 	p.RLock()
 	defer p.RUnlock()
+	info := make([]PeerInfo, 0, len(p.hosts))
+	for address, peer := range p.hosts {
+		info = append(info, PeerInfo{
+			Address:   address,
+			PublicKey: peer.publicKey,
+			Outbound:  peer.outbound,
+			LastSeen:  peer.lastSeen,
+			Failures:  peer.failures,
+			Latency:   peer.latency.String(),
+			Height:    peer.height,
+		})
+	}
+	return info
+}
 
-	done := make(chan bool)
+// Addresses returns the addresses of all connected peer nodes
+func (p *Peers) Addresses() []string {
+	p.RLock()
+	defer p.RUnlock()
+	addresses := make([]string, 0, len(p.hosts))
 	for host := range p.hosts {
-		go func(host string, block blockchain.Block, done chan<- bool) {
-			client := NodeClient{host}
-			err := client.SendBlock(block)
-			if err != nil {
-				log.Printf("Failed to broadcast block to %s: %v\n", host, err)
+		addresses = append(addresses, host)
+	}
+	return addresses
+}
+
+// broadcast calls every connected peer in parallel and waits for all of them to respond
+func (p *Peers) broadcast(call func(*NodeClient) error) map[string]error {
+	addresses := p.Addresses()
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	failures := make(map[string]error)
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			if err := p.contact(address, call); err != nil {
+				mutex.Lock()
+				failures[address] = err
+				mutex.Unlock()
 			}
-			done <- true
-		}(host, block, done)
+		}(address)
 	}
-	for range p.hosts {
-		<-done
+	wg.Wait()
+	return failures
+}
+
+// BroadcastBlock sends block to all known peer nodes
+func (p *Peers) BroadcastBlock(block blockchain.Block) {
+	failures := p.broadcast(func(client *NodeClient) error {
+		return client.SendBlock(block)
+	})
+	for host, err := range failures {
+		log.Printf("Failed to broadcast block to %s: %v\n", host, err)
+	}
+}
+
+// BroadcastTransaction sends transaction to all known peer nodes
+func (p *Peers) BroadcastTransaction(transaction blockchain.Transaction) {
+	failures := p.broadcast(func(client *NodeClient) error {
+		return client.SendTransaction(transaction)
+	})
+	for host, err := range failures {
+		log.Printf("Failed to relay transaction to %s: %v\n", host, err)
 	}
 }
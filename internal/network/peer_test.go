@@ -0,0 +1,109 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// servePeers serves the API of a node with the given peers and returns the address of the server
+// along with the events the API emits
+func servePeers(t *testing.T, peers *Peers) (string, chan interface{}) {
+	return serveNode(t, mineTestChain(0), peers)
+}
+
+func TestPeers(t *testing.T) {
+	t.Run("Test limiting inbound and outbound peers separately", func(t *testing.T) {
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 1, 2)
+		peers.AddInbound("inbound:1", keys.NewKeyPair().PublicKey)
+		peers.AddInbound("inbound:2", keys.NewKeyPair().PublicKey)
+		for _, address := range []string{"outbound:1", "outbound:2", "outbound:3"} {
+			peers.insert(address, true)
+		}
+		if !peers.Known("inbound:1") || peers.Known("inbound:2") {
+			t.Error("Inbound peer limit was not respected")
+		}
+		if !peers.Known("outbound:2") || peers.Known("outbound:3") {
+			t.Error("Outbound peer limit was not respected")
+		}
+		if peers.NeedsOutbound() {
+			t.Error("Peers should not need more outbound peers")
+		}
+	})
+	t.Run("Test discovering peers of peers", func(t *testing.T) {
+		self := "127.0.0.1:1"
+		discovered, _ := servePeers(t, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		known, _ := servePeers(t, testPeers(discovered, self))
+
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		peers.insert(known, true)
+		peers.Exchange(self)
+
+		if !peers.Known(discovered) {
+			t.Error("Peer of peer was not discovered")
+		}
+		if peers.Known(self) {
+			t.Error("Node added itself as a peer")
+		}
+	})
+	t.Run("Test not discovering peers beyond outbound limit", func(t *testing.T) {
+		discovered, _ := servePeers(t, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		known, _ := servePeers(t, testPeers(discovered))
+
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 1)
+		peers.insert(known, true)
+		peers.Exchange("127.0.0.1:1")
+
+		if peers.Known(discovered) {
+			t.Error("Peer was added beyond outbound limit")
+		}
+	})
+	t.Run("Test evicting unresponsive peers", func(t *testing.T) {
+		chain := mineTestChain(1)
+		alive := serveTestChain(t, chain)
+		peers := testPeers(alive, "127.0.0.1:1")
+		for i := 0; i < maxPeerFailures; i++ {
+			peers.BroadcastBlock(*chain.LastBlock())
+		}
+		if peers.Known("127.0.0.1:1") {
+			t.Error("Unresponsive peer was not evicted")
+		}
+		if !peers.Known(alive) {
+			t.Error("Responsive peer was evicted")
+		}
+		info := peers.Info()
+		if len(info) != 1 || info[0].Failures != 0 || info[0].LastSeen.IsZero() {
+			t.Errorf("Peer info was not updated: %+v", info)
+		}
+		for _, address := range peers.Book().Ready(time.Now()) {
+			if address == "127.0.0.1:1" {
+				t.Error("Evicted peer is retried without backoff")
+			}
+		}
+	})
+	t.Run("Test reconnecting to peers from address book", func(t *testing.T) {
+		address, _ := servePeers(t, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		peers.Book().Add(address)
+		peers.Reconnect("127.0.0.1:1")
+		if !peers.Known(address) {
+			t.Error("Peer from address book was not connected")
+		}
+	})
+	t.Run("Test serving peer info", func(t *testing.T) {
+		peers := NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)
+		peers.insert("127.0.0.1:1", true)
+		peers.SetHeight("127.0.0.1:1", 42)
+		address, _ := servePeers(t, peers)
+		client := NewNodeClient(NewHTTPTransport(), address)
+
+		var info []PeerInfo
+		if err := getJSON(client, "/peers/info/", &info); err != nil {
+			t.Fatal(err)
+		}
+		if len(info) != 1 || info[0].Address != "127.0.0.1:1" || !info[0].Outbound || info[0].Height != 42 {
+			t.Errorf("Unexpected peer info: %+v", info)
+		}
+	})
+}
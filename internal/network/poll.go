@@ -0,0 +1,57 @@
+package network
+
+import (
+	"log"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+)
+
+// TipPoller periodically asks peers for their chain tips and syncs from peers which are ahead, so that the
+// node catches up even if it missed block broadcasts while it was offline or partitioned from its peers
+type TipPoller struct {
+	chain  *blockchain.Blockchain
+	peers  *Peers
+	syncer *Syncer
+}
+
+// NewTipPoller returns a poller which syncs the blockchain using the syncer
+func NewTipPoller(chain *blockchain.Blockchain, peers *Peers, syncer *Syncer) *TipPoller {
+	return &TipPoller{
+		chain:  chain,
+		peers:  peers,
+		syncer: syncer,
+	}
+}
+
+// Run polls the peers at the given interval forever
+func (p *TipPoller) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.Poll()
+	}
+}
+
+// Poll asks every peer for its tip once and syncs from the peers with more work than the local chain
+func (p *TipPoller) Poll() {
+	for _, address := range p.peers.Addresses() {
+		var tip ChainTip
+		err := p.peers.contact(address, func(client *NodeClient) (err error) {
+			tip, err = client.GetTip()
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to poll tip of %s: %v\n", address, err)
+			continue
+		}
+		p.peers.SetHeight(address, tip.Number)
+		if tip.Work <= p.chain.Work() {
+			continue
+		}
+		log.Printf("Peer %s is ahead with block %d %x\n", address, tip.Number, tip.Hash)
+		if err := p.syncer.Sync(address); err != nil {
+			log.Printf("Failed to sync from %s: %v\n", address, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+)
+
+func TestTipPoller(t *testing.T) {
+	t.Run("Test catching up with peer which is ahead", func(t *testing.T) {
+		behind := mineTestChain(3)
+		ahead := mineTestChain(10)
+		peers := testPeers(serveTestChain(t, behind), serveTestChain(t, ahead), "127.0.0.1:1")
+		chain := blockchain.NewBlockchain(nil, blockchain.WithGenesis(testGenesis()))
+		NewTipPoller(chain, peers, NewSyncer(chain, peers)).Poll()
+
+		if !bytes.Equal(chain.LastBlock().Hash, ahead.LastBlock().Hash) {
+			t.Error("Chain did not catch up with the peer with most work")
+		}
+	})
+	t.Run("Test ignoring peers which are behind", func(t *testing.T) {
+		chain := mineTestChain(5)
+		tip := chain.LastBlock()
+		peers := testPeers(serveTestChain(t, mineTestChain(3)))
+		NewTipPoller(chain, peers, NewSyncer(chain, peers)).Poll()
+
+		if chain.LastBlock() != tip {
+			t.Error("Chain switched to peer with less work")
+		}
+	})
+}
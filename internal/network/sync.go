@@ -0,0 +1,240 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+)
+
+const (
+	headersPerRequest = maxHeadersPerPage
+	blocksPerRequest  = 100
+	// maxHeadersPerSync is the number of headers downloaded before their blocks are synced, which bounds
+	// the memory a peer serving an endless chain of headers can use
+	maxHeadersPerSync = 10 * headersPerRequest
+)
+
+// blockBatch is a range of consecutive blocks downloaded with a single request
+type blockBatch struct {
+	from  int
+	count int
+}
+
+// batchResult holds the downloaded blocks of a batch
+type batchResult struct {
+	batch  blockBatch
+	blocks []blockchain.Block
+}
+
+// Syncer catches the blockchain up with the main chain of a peer. The headers of the peer's main chain are
+// downloaded and validated first, after which the blocks matching them are downloaded in parallel from all
+// known peers and added to the blockchain in order. Added blocks are persisted by the blockchain, so an
+// interrupted sync continues from the last added block the next time it is run. Headers are downloaded in
+// windows of at most maxHeaders headers, and the sync stops once a window does not have more work than
+// the local chain, so branches which fork further back than a window are never synced.
+type Syncer struct {
+	sync.Mutex
+	chain      *blockchain.Blockchain
+	peers      *Peers
+	maxHeaders int
+}
+
+// NewSyncer returns a syncer which downloads blocks to the blockchain from the given peers
+func NewSyncer(chain *blockchain.Blockchain, peers *Peers) *Syncer {
+	return &Syncer{
+		chain:      chain,
+		peers:      peers,
+		maxHeaders: maxHeadersPerSync,
+	}
+}
+
+// Sync downloads the main chain of the peer at the given address if it has more work than the local one
+func (s *Syncer) Sync(address string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	client := s.peers.client(address)
+	for {
+		headers, err := s.downloadHeaders(client)
+		if err != nil {
+			return fmt.Errorf("Failed to download headers from %s: %v", address, err)
+		}
+		if headers == nil || headers.Len() == 0 || headers.Work() <= s.chain.Work() {
+			return nil
+		}
+		log.Printf("Syncing blocks %d to %d with headers from %s\n", headers.Anchor().Number+1, headers.Tip().Number, address)
+		if err := s.downloadBlocks(headers, address); err != nil {
+			return err
+		}
+		// The peer may have more headers only if the window was filled
+		if headers.Len() < s.maxHeaders {
+			return nil
+		}
+	}
+}
+
+// headersToRequest returns how many headers to request next when the window already holds the given
+// number of headers
+func (s *Syncer) headersToRequest(downloaded int) int {
+	if remaining := s.maxHeaders - downloaded; remaining < headersPerRequest {
+		return remaining
+	}
+	return headersPerRequest
+}
+
+// downloadHeaders downloads and validates the headers of the peer's main chain which are above the local
+// tip. If the headers do not connect to a known block, the peer is on another branch, so the download is
+// started further back exponentially until they do. No headers are returned if the peer only has the
+// genesis block.
+func (s *Syncer) downloadHeaders(client *NodeClient) (*blockchain.HeaderChain, error) {
+	from := s.chain.LastBlock().Number + 1
+	for step := 1; ; step *= 2 {
+		page, err := client.GetHeaders(from, s.headersToRequest(0))
+		if err != nil {
+			return nil, err
+		}
+		if len(page) > 0 {
+			headers, err := s.chain.NewHeaderChain(page[0].PreviousHash)
+			if err == nil {
+				return headers, s.appendHeaders(client, headers, page)
+			}
+			if err != blockchain.ErrUnknownParent {
+				return nil, err
+			}
+		}
+		if from == 1 {
+			if len(page) == 0 {
+				return nil, nil
+			}
+			return nil, errors.New("Peer has a different genesis block")
+		}
+		from -= step
+		if from < 1 {
+			from = 1
+		}
+	}
+}
+
+// appendHeaders validates and appends pages of headers until the peer has no more headers or the window
+// is full
+func (s *Syncer) appendHeaders(client *NodeClient, headers *blockchain.HeaderChain, page []blockchain.BlockHeader) error {
+	for len(page) > 0 {
+		// Peers may serve more headers than were requested
+		if remaining := s.maxHeaders - headers.Len(); len(page) > remaining {
+			page = page[:remaining]
+		}
+		if err := headers.Append(page); err != nil {
+			return err
+		}
+		if headers.Len() >= s.maxHeaders {
+			return nil
+		}
+		var err error
+		page, err = client.GetHeaders(headers.Tip().Number+1, s.headersToRequest(headers.Len()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadBlocks downloads the blocks matching the headers in batches from all known peers and the peer
+// the headers came from. A peer which fails to provide a batch is not used for the rest of the sync and
+// its batch is retried using the other peers.
+func (s *Syncer) downloadBlocks(headers *blockchain.HeaderChain, source string) error {
+	first := headers.Anchor().Number + 1
+	last := headers.Tip().Number
+	batches := make(chan blockBatch, (last-first)/blocksPerRequest+1)
+	for from := first; from <= last; from += blocksPerRequest {
+		count := blocksPerRequest
+		if from+count > last+1 {
+			count = last + 1 - from
+		}
+		batches <- blockBatch{from, count}
+	}
+
+	addresses := map[string]bool{source: true}
+	for _, address := range s.peers.Addresses() {
+		addresses[address] = true
+	}
+	results := make(chan batchResult)
+	done := make(chan struct{})
+	defer close(done)
+	var workers sync.WaitGroup
+	for address := range addresses {
+		workers.Add(1)
+		go func(client *NodeClient) {
+			defer workers.Done()
+			fetchBatches(client, headers, batches, results, done)
+		}(s.peers.client(address))
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// Batches arrive in any order but are added to the blockchain in order
+	pending := make(map[int][]blockchain.Block)
+	for next := first; next <= last; {
+		result, ok := <-results
+		if !ok {
+			return fmt.Errorf("No peer could provide blocks from %d onwards", next)
+		}
+		pending[result.batch.from] = result.blocks
+		for blocks, ok := pending[next]; ok; blocks, ok = pending[next] {
+			delete(pending, next)
+			for i := range blocks {
+				if err := s.chain.AddBlock(&blocks[i]); err != nil {
+					return fmt.Errorf("Failed to add synced block %v: %v", blocks[i], err)
+				}
+			}
+			next += len(blocks)
+		}
+		log.Printf("Synced blocks up to %d of %d\n", next-1, last)
+	}
+	return nil
+}
+
+// fetchBatches downloads batches using the client until there are no more batches or the client fails
+func fetchBatches(client *NodeClient, headers *blockchain.HeaderChain, batches chan blockBatch, results chan<- batchResult, done <-chan struct{}) {
+	for {
+		var batch blockBatch
+		select {
+		case batch = <-batches:
+		case <-done:
+			return
+		}
+		blocks, err := client.GetBlockRange(batch.from, batch.count)
+		if err == nil {
+			err = matchHeaders(headers, batch, blocks)
+		}
+		if err != nil {
+			log.Printf("Failed to download blocks %d to %d from %s: %v\n", batch.from, batch.from+batch.count-1, client.peerAddress, err)
+			batches <- batch
+			return
+		}
+		select {
+		case results <- batchResult{batch, blocks}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// matchHeaders checks that the downloaded blocks are the ones the validated headers describe
+func matchHeaders(headers *blockchain.HeaderChain, batch blockBatch, blocks []blockchain.Block) error {
+	if len(blocks) != batch.count {
+		return fmt.Errorf("Expected %d blocks but received %d", batch.count, len(blocks))
+	}
+	for i, block := range blocks {
+		header, ok := headers.Header(batch.from + i)
+		if !ok || !bytes.Equal(block.Hash, header.Hash()) {
+			return fmt.Errorf("Block %d does not match its header", batch.from+i)
+		}
+	}
+	return nil
+}
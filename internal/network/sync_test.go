@@ -0,0 +1,181 @@
+package network
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// testGenesis returns a genesis block with the lowest difficulty so that blocks can be mined instantly
+func testGenesis() *blockchain.Block {
+	genesis := &blockchain.Block{
+		Number:     0,
+		Time:       time.Date(2021, time.May, 1, 6, 0, 0, 0, time.UTC),
+		Difficulty: 1,
+	}
+	genesis.Hash = genesis.ComputeHash()
+	return genesis
+}
+
+// testIdentity returns a random identity on the network of the test genesis block
+func testIdentity() *Identity {
+	return NewIdentity(keys.NewKeyPair(), "test", testGenesis().Hash, "127.0.0.1:1")
+}
+
+// mineTestChain returns a blockchain with the given number of mined blocks on top of the test genesis block
+func mineTestChain(blocks int) *blockchain.Blockchain {
+	chain := blockchain.NewBlockchain(keys.NewKeyPair(), blockchain.WithGenesis(testGenesis()))
+	for i := 0; i < blocks; i++ {
+		chain.MineBlock()
+	}
+	return chain
+}
+
+// mainChain returns the blocks on the main chain of the blockchain
+func mainChain(chain *blockchain.Blockchain) []blockchain.Block {
+	blocks := []blockchain.Block{*chain.LastBlock()}
+	for blocks[0].Number > 0 {
+		parent, _ := chain.BlockByHash(blocks[0].PreviousHash)
+		blocks = append([]blockchain.Block{*parent}, blocks...)
+	}
+	return blocks
+}
+
+// serveNode serves the API of a node running the chain with the given peers and returns the address of
+// the server along with the events the API emits. The blocks of the chain are cached as they would be by a
+// running node and the API is served over TLS if the transport of the peers is configured for it.
+func serveNode(t *testing.T, chain *blockchain.Blockchain, peers *Peers) (string, chan interface{}) {
+	events := make(chan interface{}, 64)
+	api := NewApi(chain, peers, events)
+	api.updateCache(mainChain(chain))
+	server := httptest.NewUnstartedServer(api.Handler())
+	t.Cleanup(server.Close)
+	if transport, ok := peers.transport.(*HTTPTransport); ok && transport.server != nil {
+		server.TLS = transport.server
+		server.StartTLS()
+		return strings.TrimPrefix(server.URL, "https://"), events
+	}
+	server.Start()
+	return strings.TrimPrefix(server.URL, "http://"), events
+}
+
+// serveTestChain serves the API of the blockchain and returns the address of the server
+func serveTestChain(t *testing.T, chain *blockchain.Blockchain) string {
+	address, _ := serveNode(t, chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+	return address
+}
+
+// testPeers returns outbound peers with the given addresses without greeting them
+func testPeers(addresses ...string) *Peers {
+	peers := NewPeers(testIdentity(), NewHTTPTransport(), len(addresses), len(addresses))
+	for _, address := range addresses {
+		peers.insert(address, true)
+	}
+	return peers
+}
+
+func TestSync(t *testing.T) {
+	source := mineTestChain(2*blocksPerRequest + 10)
+	address := serveTestChain(t, source)
+
+	t.Run("Test paginating headers", func(t *testing.T) {
+		client := NewNodeClient(NewHTTPTransport(), address)
+		headers, err := client.GetHeaders(5, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(headers) != 10 || headers[0].Number != 5 || headers[9].Number != 14 {
+			t.Error("Received wrong page of headers")
+		}
+		blocks, err := client.GetBlockRange(source.LastBlock().Number, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 1 || !bytes.Equal(blocks[0].Hash, source.LastBlock().Hash) {
+			t.Error("Received wrong page of blocks")
+		}
+	})
+	t.Run("Test syncing from scratch using several peers", func(t *testing.T) {
+		chain := blockchain.NewBlockchain(nil, blockchain.WithGenesis(testGenesis()))
+		peers := testPeers(serveTestChain(t, source))
+		if err := NewSyncer(chain, peers).Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if !bytes.Equal(chain.LastBlock().Hash, source.LastBlock().Hash) {
+			t.Error("Synced chain does not match source chain")
+		}
+	})
+	t.Run("Test resuming sync from partially synced chain", func(t *testing.T) {
+		chain := blockchain.NewBlockchain(nil, blockchain.WithGenesis(testGenesis()))
+		blocks := mainChain(source)
+		for i := 1; i < 50; i++ {
+			if err := chain.AddBlock(&blocks[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := NewSyncer(chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)).Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if !bytes.Equal(chain.LastBlock().Hash, source.LastBlock().Hash) {
+			t.Error("Synced chain does not match source chain")
+		}
+	})
+	t.Run("Test syncing chain on another branch", func(t *testing.T) {
+		chain := mineTestChain(5)
+		if err := NewSyncer(chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)).Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if !bytes.Equal(chain.LastBlock().Hash, source.LastBlock().Hash) {
+			t.Error("Chain did not reorganize onto source chain")
+		}
+	})
+	t.Run("Test retrying blocks from another peer when a peer fails", func(t *testing.T) {
+		chain := blockchain.NewBlockchain(nil, blockchain.WithGenesis(testGenesis()))
+		// A peer on another branch serves blocks which do not match the headers
+		peers := testPeers(serveTestChain(t, mineTestChain(2*blocksPerRequest+10)), "127.0.0.1:1")
+		if err := NewSyncer(chain, peers).Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if !bytes.Equal(chain.LastBlock().Hash, source.LastBlock().Hash) {
+			t.Error("Synced chain does not match source chain")
+		}
+	})
+	t.Run("Test not syncing from peer with less work", func(t *testing.T) {
+		chain := mineTestChain(2*blocksPerRequest + 20)
+		tip := chain.LastBlock()
+		if err := NewSyncer(chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8)).Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if chain.LastBlock() != tip {
+			t.Error("Chain switched to peer with less work")
+		}
+	})
+	t.Run("Test syncing in windows of headers", func(t *testing.T) {
+		chain := blockchain.NewBlockchain(nil, blockchain.WithGenesis(testGenesis()))
+		syncer := NewSyncer(chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		syncer.maxHeaders = 30
+		if err := syncer.Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if !bytes.Equal(chain.LastBlock().Hash, source.LastBlock().Hash) {
+			t.Error("Synced chain does not match source chain")
+		}
+	})
+	t.Run("Test not syncing branch which needs more than a window to beat local work", func(t *testing.T) {
+		chain := mineTestChain(50)
+		tip := chain.LastBlock()
+		syncer := NewSyncer(chain, NewPeers(testIdentity(), NewHTTPTransport(), 8, 8))
+		syncer.maxHeaders = 30
+		if err := syncer.Sync(address); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if chain.LastBlock() != tip {
+			t.Error("Chain switched to branch forking further back than a window")
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// certificateValidity is how long node certificates are valid for. Certificates are generated again
+// every time a node starts, so this only needs to cover the uptime of a node.
+const certificateValidity = 10 * 365 * 24 * time.Hour
+
+// NewTLSTransport returns a transport which communicates over TLS. Nodes present a self-signed certificate
+// for their identity key, which is nil for clients without an identity, e.g. wallets. Since the
+// certificates are self-signed, the identity is what authenticates a node: a handshake fails unless the
+// certificate of the other node is for the identity it proves to own. With mutual authentication nodes
+// also present their certificate when calling other nodes, and require the same from nodes which shake
+// hands with them.
+func NewTLSTransport(identityKey *keys.KeyPair, mutual bool) (*HTTPTransport, error) {
+	var certificates []tls.Certificate
+	if identityKey != nil {
+		certificate, err := nodeCertificate(identityKey)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	clientTLS := &tls.Config{
+		// Certificates are verified against the identity of the node instead of a certificate authority
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyNodeCertificate,
+		MinVersion:            tls.VersionTLS12,
+	}
+	if mutual {
+		clientTLS.Certificates = certificates
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = clientTLS
+	return &HTTPTransport{
+		client: &http.Client{Timeout: requestTimeout, Transport: transport},
+		server: serverConfig(certificates),
+		mutual: mutual,
+	}, nil
+}
+
+// serverConfig returns the TLS configuration for serving the API with the given certificates. Clients are
+// asked for a certificate but only need to present one during handshakes with mutual authentication.
+func serverConfig(certificates []tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates:          certificates,
+		ClientAuth:            tls.RequestClientCert,
+		VerifyPeerCertificate: verifyNodeCertificate,
+		MinVersion:            tls.VersionTLS12,
+	}
+}
+
+// nodeCertificate returns a self-signed certificate for the identity key
+func nodeCertificate(identityKey *keys.KeyPair) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: identityKey.PublicKeyBase64},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, identityKey.PublicKey, identityKey.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: identityKey.PrivateKey}, nil
+}
+
+// verifyNodeCertificate checks that a certificate presented by a node is a valid self-signed certificate
+// for an Ed25519 key. Which identity the key belongs to is checked separately.
+func verifyNodeCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	// Clients are not required to present a certificate outside of handshakes
+	if len(rawCerts) == 0 {
+		return nil
+	}
+	certificate, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := certificate.PublicKey.(ed25519.PublicKey); !ok {
+		return errors.New("Node certificate is not for an Ed25519 key")
+	}
+	now := time.Now()
+	if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+		return errors.New("Node certificate has expired")
+	}
+	return certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature)
+}
+
+// certificateKey returns the identity key the other side of the connection presented a certificate for,
+// or nil if the connection is not encrypted or no certificate was presented
+func certificateKey(state *tls.ConnectionState) ed25519.PublicKey {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	key, _ := state.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+	return key
+}
+
+// matchesCertificate tells whether the connection is bound to the identity with the given public key. A
+// connection without TLS is not bound to any identity, so it only matches if the transport is not secure.
+func matchesCertificate(state *tls.ConnectionState, publicKey []byte, secure bool) bool {
+	if state == nil {
+		return !secure
+	}
+	return bytes.Equal(certificateKey(state), publicKey)
+}
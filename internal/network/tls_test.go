@@ -0,0 +1,69 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+// testTLSTransport returns a TLS transport presenting a certificate for the given key
+func testTLSTransport(t *testing.T, identityKey *keys.KeyPair, mutual bool) *HTTPTransport {
+	transport, err := NewTLSTransport(identityKey, mutual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport
+}
+
+func TestTLS(t *testing.T) {
+	t.Run("Test handshake with mutual authentication", func(t *testing.T) {
+		client := testIdentity()
+		server := testIdentity()
+		address, _ := servePeers(t, NewPeers(server, testTLSTransport(t, server.keyPair, true), 8, 8))
+
+		transport := testTLSTransport(t, client.keyPair, true)
+		peers := NewPeers(client, transport, 8, 8)
+		peers.Add(address)
+		if !peers.Known(address) {
+			t.Fatal("Handshake over TLS failed")
+		}
+		if _, err := NewNodeClient(transport, address).GetTip(); err != nil {
+			t.Errorf("Request over TLS failed: %v", err)
+		}
+	})
+	t.Run("Test refusing certificate for another identity", func(t *testing.T) {
+		client := testIdentity()
+		address, _ := servePeers(t, NewPeers(testIdentity(), testTLSTransport(t, keys.NewKeyPair(), false), 8, 8))
+
+		peers := NewPeers(client, testTLSTransport(t, client.keyPair, false), 8, 8)
+		peers.Add(address)
+		if peers.Known(address) {
+			t.Error("Peer with certificate for another identity was accepted")
+		}
+	})
+	t.Run("Test requiring client certificate for identity with mutual authentication", func(t *testing.T) {
+		client := testIdentity()
+		server := testIdentity()
+		address, _ := servePeers(t, NewPeers(server, testTLSTransport(t, server.keyPair, true), 8, 8))
+
+		peers := NewPeers(client, testTLSTransport(t, keys.NewKeyPair(), true), 8, 8)
+		peers.Add(address)
+		if peers.Known(address) {
+			t.Error("Peer with client certificate for another identity was accepted")
+		}
+	})
+	t.Run("Test pinning certificate of known peer", func(t *testing.T) {
+		server := testIdentity()
+		address, _ := servePeers(t, NewPeers(server, testTLSTransport(t, server.keyPair, false), 8, 8))
+
+		pinned := NewNodeClient(testTLSTransport(t, nil, false), address)
+		pinned.PinIdentity(server.PublicKey())
+		if _, err := pinned.GetTip(); err != nil {
+			t.Errorf("Request to peer with matching certificate failed: %v", err)
+		}
+		pinned.PinIdentity(testIdentity().PublicKey())
+		if _, err := pinned.GetTip(); err == nil {
+			t.Error("Request to peer with certificate for another identity succeeded")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// requestTimeout bounds how long a request to an unresponsive peer can block
+const requestTimeout = 10 * time.Second
+
+// Transport carries the API requests between nodes. Nodes normally talk over HTTP, but nodes in the same
+// process can also be connected via an in-memory network, e.g. to test how a network of nodes behaves.
+type Transport interface {
+	// Do sends the request to the node at the host of the request URL
+	Do(request *http.Request) (*http.Response, error)
+	// Serve serves the API of a node at the given address
+	Serve(address string, handler http.Handler) error
+	// Secure tells whether requests are sent over TLS, in which case the other side of a connection is
+	// identified by its certificate
+	Secure() bool
+	// Mutual tells whether nodes need to present a certificate for their identity during handshakes
+	Mutual() bool
+}
+
+// HTTPTransport carries requests between nodes over HTTP, optionally encrypted with TLS
+type HTTPTransport struct {
+	client *http.Client
+	// server is the TLS configuration the API is served with, or nil if TLS is disabled
+	server *tls.Config
+	mutual bool
+}
+
+// NewHTTPTransport returns a transport which communicates over plain HTTP. This is meant for local
+// development only.
+func NewHTTPTransport() *HTTPTransport {
+	return &HTTPTransport{client: &http.Client{Timeout: requestTimeout}}
+}
+
+// Do sends the request over HTTP
+func (t *HTTPTransport) Do(request *http.Request) (*http.Response, error) {
+	return t.client.Do(request)
+}
+
+// Serve listens for requests at the given address until the server fails
+func (t *HTTPTransport) Serve(address string, handler http.Handler) error {
+	if t.server == nil {
+		return http.ListenAndServe(address, handler)
+	}
+	server := &http.Server{Addr: address, Handler: handler, TLSConfig: t.server}
+	return server.ListenAndServeTLS("", "")
+}
+
+// Secure tells whether the transport uses TLS
+func (t *HTTPTransport) Secure() bool {
+	return t.server != nil
+}
+
+// Mutual tells whether the transport uses mutual authentication
+func (t *HTTPTransport) Mutual() bool {
+	return t.mutual
+}
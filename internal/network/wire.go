@@ -0,0 +1,130 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+)
+
+const (
+	// binaryContentType is the content type of messages using the binary encoding of the blockchain package.
+	// Nodes use it to talk to each other while JSON remains available for users of the API.
+	binaryContentType = "application/octet-stream"
+	// maxMessageSize limits the size of a single block or transaction sent to the API
+	maxMessageSize = 1 << 20
+)
+
+// isBinary indicates whether the request body uses the binary encoding
+func isBinary(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == binaryContentType
+}
+
+// acceptsBinary indicates whether the client asked for a binary encoded response
+func acceptsBinary(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Accept"))
+	return err == nil && mediaType == binaryContentType
+}
+
+// readMessage reads a binary encoded block or transaction from the request body
+func readMessage(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, errors.New("Message is too large")
+	}
+	return body, nil
+}
+
+// encodeList encodes a list as the number of items followed by each encoded item prefixed with its
+// length, all lengths being unsigned 32-bit big-endian integers
+func encodeList(count int, item func(int) ([]byte, error)) ([]byte, error) {
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, uint32(count))
+	for i := 0; i < count; i++ {
+		payload, err := item(i)
+		if err != nil {
+			return nil, err
+		}
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(payload)))
+		encoded = append(encoded, length...)
+		encoded = append(encoded, payload...)
+	}
+	return encoded, nil
+}
+
+// decodeList decodes a list encoded with encodeList, passing each encoded item to item
+func decodeList(data []byte, item func([]byte) error) error {
+	if len(data) < 4 {
+		return errors.New("List is truncated")
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 4 {
+			return errors.New("List is truncated")
+		}
+		length := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(length) {
+			return errors.New("List is truncated")
+		}
+		if err := item(data[:length]); err != nil {
+			return err
+		}
+		data = data[length:]
+	}
+	if len(data) > 0 {
+		return errors.New("List has trailing bytes")
+	}
+	return nil
+}
+
+// encodeBlocks encodes a list of blocks
+func encodeBlocks(blocks []blockchain.Block) ([]byte, error) {
+	return encodeList(len(blocks), func(i int) ([]byte, error) {
+		return blocks[i].MarshalBinary()
+	})
+}
+
+// decodeBlocks decodes a list of blocks encoded with encodeBlocks
+func decodeBlocks(data []byte) ([]blockchain.Block, error) {
+	blocks := []blockchain.Block{}
+	err := decodeList(data, func(payload []byte) error {
+		var block blockchain.Block
+		if err := block.UnmarshalBinary(payload); err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		return nil
+	})
+	return blocks, err
+}
+
+// encodeHeaders encodes a list of block headers
+func encodeHeaders(headers []blockchain.BlockHeader) ([]byte, error) {
+	return encodeList(len(headers), func(i int) ([]byte, error) {
+		return headers[i].MarshalBinary()
+	})
+}
+
+// decodeHeaders decodes a list of block headers encoded with encodeHeaders
+func decodeHeaders(data []byte) ([]blockchain.BlockHeader, error) {
+	headers := []blockchain.BlockHeader{}
+	err := decodeList(data, func(payload []byte) error {
+		var header blockchain.BlockHeader
+		if err := header.UnmarshalBinary(payload); err != nil {
+			return err
+		}
+		headers = append(headers, header)
+		return nil
+	})
+	return headers, err
+}
@@ -0,0 +1,35 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coocos/cryptocurrency/internal/blockchain"
+	"github.com/coocos/cryptocurrency/internal/keys"
+)
+
+func TestWire(t *testing.T) {
+	t.Run("Test encoding and decoding block list", func(t *testing.T) {
+		genesis := *blockchain.GenesisBlock()
+		miner := keys.NewKeyPair()
+		block := *blockchain.NewBlock(1, genesis.Hash, genesis.Difficulty, []blockchain.Transaction{blockchain.CoinbaseTransactionTo(miner.PublicKey, 0)}, 1)
+
+		encoded, err := encodeBlocks([]blockchain.Block{genesis, block})
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeBlocks(encoded)
+		if err != nil {
+			t.Fatalf("Failed to decode blocks: %v\n", err)
+		}
+		if len(decoded) != 2 || !bytes.Equal(decoded[0].Hash, genesis.Hash) || !bytes.Equal(decoded[1].Hash, block.Hash) {
+			t.Error("Decoded blocks differ from encoded blocks")
+		}
+	})
+	t.Run("Test decoding truncated block list", func(t *testing.T) {
+		encoded, _ := encodeBlocks([]blockchain.Block{*blockchain.GenesisBlock()})
+		if _, err := decodeBlocks(encoded[:len(encoded)-1]); err == nil {
+			t.Error("Decoding truncated block list did not fail")
+		}
+	})
+}